@@ -2,8 +2,11 @@ package main
 
 import (
 	"advsql/internal/app"
+	"advsql/internal/config"
 	"advsql/internal/database"
-	"fmt"
+	"advsql/internal/logging"
+	"log/slog"
+	"os"
 )
 
 // @title           GO REST API WITH DIRECT SQL
@@ -22,7 +25,25 @@ import (
 // @BasePath  /
 
 func main() {
-	fmt.Println("Сервер запущен на порту 8080")
-	database.ConnectDB()
-	app.Run()
+	logging.Setup(os.Stderr, config.AppConfig.LogLevel)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if err := database.ConnectDB(); err != nil {
+		fatal(err)
+	}
+	if err := app.Run(); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error("Exiting", "error", err)
+	os.Exit(1)
 }
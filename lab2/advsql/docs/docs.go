@@ -24,9 +24,156 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/audit": {
+            "get": {
+                "description": "Get the audit trail of all users, newest first, optionally filtered. since and until are RFC 3339 timestamps; since is inclusive and until exclusive.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "audit"
+                ],
+                "summary": "Get audit feed",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "create",
+                            "update",
+                            "delete",
+                            "restore",
+                            "bulk_load"
+                        ],
+                        "type": "string",
+                        "description": "Action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed actor, as sent in X-Actor",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "request_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Earliest change time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Latest change time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.AuditListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Reports that the process is up and serving HTTP. It does not look at any dependency, so a failing database does not get the service restarted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Pings the database and checks that the schema is up to date, with a timeout, and reports the status of each. Traffic should only be sent while this returns 200.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    },
+                    "503": {
+                        "description": "A dependency is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
         "/users": {
             "get": {
-                "description": "Get a paginated list of users with optional filters for age and sorting in ascending or descending order.",
+                "description": "Get a paginated list of users with optional filters for age and sorting in ascending or descending order.\nPassing the cursor parameter (empty for the first page) switches to keyset pagination: no total count is computed and the response carries next_cursor/prev_cursor instead of page numbers.",
                 "consumes": [
                     "application/json"
                 ],
@@ -64,9 +211,27 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Sort by name in ascending or descending order",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age; direction asc (default) or desc. Ties are broken by id.",
                         "name": "sort",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque cursor from next_cursor or prev_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also list users in the trash",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all.",
+                        "name": "fields",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -76,16 +241,34 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.UserListResponse"
                         }
                     },
+                    "400": {
+                        "description": "Invalid cursor, sort or fields",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
             },
-            "post": {
-                "description": "Create a new users",
+            "put": {
+                "description": "Create users whose name does not exist yet and update the age of those that do. The body is a list of users or a single user object. The batch is applied atomically and the response reports, per user, whether it was created or updated.",
                 "consumes": [
                     "application/json"
                 ],
@@ -95,10 +278,10 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Create few users at once",
+                "summary": "Upsert users",
                 "parameters": [
                     {
-                        "description": "User to create",
+                        "description": "Users to create or update",
                         "name": "user",
                         "in": "body",
                         "required": true,
@@ -108,33 +291,55 @@ const docTemplate = `{
                                 "$ref": "#/definitions/models.User"
                             }
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.UpsertResponse"
                         }
                     },
                     "400": {
                         "description": "Invalid request payload",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed or data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
-            }
-        },
-        "/users/{id}": {
-            "put": {
-                "description": "Update user details by ID",
+            },
+            "post": {
+                "description": "Create a new users. By default the batch is all-or-nothing and is refused with 422 if any user fails validation; with atomic=false every user is validated and inserted on its own and the response lists the outcome of each one.",
                 "consumes": [
                     "application/json"
                 ],
@@ -144,56 +349,91 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Update user",
+                "summary": "Create few users at once",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Updated user",
+                        "description": "User to create",
                         "name": "user",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.User"
+                            }
                         }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to keep valid rows when others fail",
+                        "name": "atomic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "type": "string"
+                        }
+                    },
+                    "207": {
+                        "description": "Multi-Status",
+                        "schema": {
+                            "$ref": "#/definitions/models.BulkCreateResponse"
                         }
                     },
                     "400": {
                         "description": "Invalid request payload",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
-                    "404": {
-                        "description": "User not found",
+                    "409": {
+                        "description": "User name already exists",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed or data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete user by ID",
+            }
+        },
+        "/users/bulk": {
+            "post": {
+                "description": "Stream users into the database with COPY. The body is either a JSON array or newline delimited JSON (Content-Type application/x-ndjson) and is decoded and validated while it is being copied. The load is all-or-nothing: the first invalid user fails it with 422.\nThe upload and the load together must finish within the route timeout (5 minutes by default, unless ROUTE_TIMEOUTS sets another for POST /users/bulk); slower requests are cut off.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-ndjson"
                 ],
                 "produces": [
                     "application/json"
@@ -201,66 +441,1219 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Delete user",
+                "summary": "Bulk load users",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "User ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Users to load",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.User"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.BulkLoadResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid user ID",
+                        "description": "Invalid request payload",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
-                    "404": {
-                        "description": "User not found",
+                    "409": {
+                        "description": "User name already exists",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed or data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.User": {
-            "type": "object",
-            "properties": {
-                "age": {
-                    "description": "The user's age.\nexample: 30",
-                    "type": "integer"
-                },
-                "id": {
-                    "description": "The user's ID.\nexample: 1",
-                    "type": "integer"
+        },
+        "/users/export": {
+            "get": {
+                "description": "Stream every user matching the filters of GET /users, without paging, as CSV, newline-delimited JSON or a JSON array. Rows are read from a single database cursor and flushed to the client as they arrive, so exports of any size use constant memory.\nIn CSV, text starting with =, +, -, @, a tab or a carriage return is prefixed with an apostrophe so that spreadsheets do not run it as a formula.\nIf the export fails after it has started, the connection is closed before the document is complete.\nThe whole download must finish within the route timeout (10 minutes by default, unless ROUTE_TIMEOUTS sets another for GET /users/export); slower exports are cut off.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Export users",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson",
+                            "json"
+                        ],
+                        "type": "string",
+                        "default": "csv",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age; direction asc (default) or desc. Ties are broken by id.",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.User"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid format or sort",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/import": {
+            "post": {
+                "description": "Create users from a CSV file with a header row. Columns are matched to fields by header name, case-insensitively; use map=field:Header to read a field from a differently named column. Every row is validated and problems are reported by line, counting the header as line 1. By default nothing is written if any row is invalid; with atomic=false the valid rows are imported and the rest reported. dry_run=true only validates the file.\nThe upload and the import together must finish within the route timeout (5 minutes by default, unless ROUTE_TIMEOUTS sets another for POST /users/import).",
+                "consumes": [
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Import users from CSV",
+                "parameters": [
+                    {
+                        "description": "CSV file with a header row",
+                        "name": "file",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Column mapping as field:Header, e.g. name:Full Name",
+                        "name": "map",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Validate the file without writing anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Set to false to import the valid rows when others fail",
+                        "name": "atomic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dry run report",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportReport"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportReport"
+                        }
+                    },
+                    "207": {
+                        "description": "Some rows were not imported",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid CSV file or column mapping",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported content type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Some rows are invalid",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportReport"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/stats": {
+            "get": {
+                "description": "Get the number of users, their minimum, maximum, mean and median age, age percentiles and an age histogram. Accepts the same filters as GET /users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "User statistics",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also count users in the trash",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Width of the histogram buckets in years (default 10)",
+                        "name": "bucket_width",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserStats"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid bucket width",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/trash": {
+            "get": {
+                "description": "Get a paginated list of soft-deleted users. Accepts the same filters, sorting and pagination as GET /users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List deleted users",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age; direction asc (default) or desc. Ties are broken by id.",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque cursor from next_cursor or prev_cursor",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all.",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor, sort or fields",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "description": "Get a user by ID. The response carries a strong ETag derived from the user's data and the requested fields; sending it back in If-None-Match returns 304 Not Modified while the user is unchanged. Only the ETag of the whole user is accepted in If-Match by writes.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all.",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID or fields",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update user details by ID. The write is conditional on the version the client last read, given as the ETag in If-Match or as the version field; if the user changed since, the current user is returned with 412.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being updated",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Updated user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request payload",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "412": {
+                        "description": "User was modified; current user",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "422": {
+                        "description": "Validation failed or data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match header or version field required",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Move a user to the trash by ID. It can be brought back with POST /users/{id}/restore.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Update only the fields present in the patch. The body is either a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json) or a JSON Patch (RFC 6902, Content-Type application/json-patch+json). The patched user is validated again and written in a single transaction.\nThe write is conditional on the version the client last read, given as the ETag in If-Match, as a version member of a merge patch or as a test operation on /version; if the user changed since, the current user is returned with 412.",
+                "consumes": [
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Patch user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being patched",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Merge patch object or array of JSON Patch operations",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Malformed patch document",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "Test operation failed or user name already exists",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "412": {
+                        "description": "User was modified; current user",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported patch media type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Patch cannot be applied or result is invalid",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match header or version required",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/history": {
+            "get": {
+                "description": "Get the audit trail of a user, newest first: every create, update, delete and restore with the user before and after the change, who made it and in which request. Deleted users keep their history.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "audit"
+                ],
+                "summary": "Get user history",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.AuditListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/restore": {
+            "post": {
+                "description": "Bring a user back from the trash by ID.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Restore user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User is not deleted",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/health.Result"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                }
+            }
+        },
+        "health.Result": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Error says why the check failed.",
+                    "type": "string",
+                    "example": "context deadline exceeded"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                }
+            }
+        },
+        "models.AgeBucket": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "The number of users in the bucket.\nexample: 12",
+                    "type": "integer"
+                },
+                "from": {
+                    "description": "The lowest age in the bucket.\nexample: 20",
+                    "type": "integer"
+                },
+                "to": {
+                    "description": "The lowest age of the next bucket.\nexample: 30",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.AuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "One of create, update, delete, restore or bulk_load.\nexample: update",
+                    "type": "string"
+                },
+                "actor": {
+                    "description": "The claimed actor: who the client said made the change, from the\nX-Actor request header. The header is not authenticated.\nexample: alice",
+                    "type": "string"
+                },
+                "after": {
+                    "description": "The user after the change. Not set for delete. For bulk_load, the\nnumber of users loaded, e.g. {\"inserted\": 1000}.",
+                    "type": "object"
+                },
+                "before": {
+                    "description": "The user before the change. Not set for create and bulk_load.",
+                    "type": "object"
+                },
+                "created_at": {
+                    "description": "When the change was made.\nexample: 2024-01-02T15:04:05Z",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The entry's ID.\nexample: 1",
+                    "type": "integer"
+                },
+                "request_id": {
+                    "description": "The ID of the request that made the change.\nexample: 4bf92f3577b34da6a3ce929d0e0e4736",
+                    "type": "string"
+                },
+                "user_id": {
+                    "description": "The ID of the changed user, or 0 for a bulk_load entry.\nexample: 1",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.AuditListResponse": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "description": "The list of entries.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AuditEntry"
+                    }
+                },
+                "page": {
+                    "description": "The current page number.\nexample: 1",
+                    "type": "integer"
+                },
+                "page_size": {
+                    "description": "The size of each page.\nexample: 10",
+                    "type": "integer"
+                },
+                "total_items": {
+                    "description": "The total number of matching entries.\nexample: 100",
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "description": "The total number of pages.\nexample: 10",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BulkCreateResponse": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "description": "The number of users created.\nexample: 9",
+                    "type": "integer"
+                },
+                "failed": {
+                    "description": "The number of users rejected.\nexample: 1",
+                    "type": "integer"
+                },
+                "results": {
+                    "description": "The result of every user, in request order.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BulkCreateResult"
+                    }
+                }
+            }
+        },
+        "models.BulkCreateResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Why the user was not created.\nexample: a user with name \"John Doe\" already exists",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The ID of the created user.\nexample: 1",
+                    "type": "integer"
+                },
+                "index": {
+                    "description": "The position of the user in the request body.\nexample: 0",
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "The HTTP status of this element.\nexample: 201",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.BulkLoadResponse": {
+            "type": "object",
+            "properties": {
+                "duration_ms": {
+                    "description": "How long the load took, in milliseconds.\nexample: 850",
+                    "type": "integer"
+                },
+                "inserted": {
+                    "description": "The number of users inserted.\nexample: 100000",
+                    "type": "integer"
+                },
+                "rows_per_second": {
+                    "description": "Insert throughput.\nexample: 117647.06",
+                    "type": "number"
+                }
+            }
+        },
+        "models.ImportError": {
+            "type": "object",
+            "properties": {
+                "column": {
+                    "description": "The CSV column the problem was found in, if any.\nexample: age",
+                    "type": "string"
+                },
+                "line": {
+                    "description": "The line of the file, counting the header as line 1.\nexample: 3",
+                    "type": "integer"
+                },
+                "message": {
+                    "description": "What is wrong with the line.\nexample: must be a whole number",
+                    "type": "string"
+                }
+            }
+        },
+        "models.ImportReport": {
+            "type": "object",
+            "properties": {
+                "dry_run": {
+                    "description": "Whether the file was only validated.\nexample: false",
+                    "type": "boolean"
+                },
+                "errors": {
+                    "description": "The problems found, ordered by line.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ImportError"
+                    }
+                },
+                "imported": {
+                    "description": "The number of users created.\nexample: 9",
+                    "type": "integer"
+                },
+                "rows": {
+                    "description": "The number of data rows in the file.\nexample: 10",
+                    "type": "integer"
+                },
+                "valid": {
+                    "description": "The number of rows that passed validation.\nexample: 9",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UpsertResponse": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "description": "The number of users created.\nexample: 3",
+                    "type": "integer"
+                },
+                "results": {
+                    "description": "The result of every user, in request order.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UpsertResult"
+                    }
+                },
+                "updated": {
+                    "description": "The number of users updated.\nexample: 7",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UpsertResult": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "Either \"created\" or \"updated\".\nexample: created",
+                    "type": "string"
+                },
+                "index": {
+                    "description": "The position of the user in the request body.\nexample: 0",
+                    "type": "integer"
+                },
+                "user": {
+                    "description": "The stored user.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    ]
+                }
+            }
+        },
+        "models.User": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "age": {
+                    "description": "The user's age.\nexample: 30",
+                    "type": "integer",
+                    "maximum": 150,
+                    "minimum": 0
+                },
+                "deleted_at": {
+                    "description": "When the user was deleted. Only set on users in the trash.\nexample: 2024-01-02T15:04:05Z",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The user's ID.\nexample: 1",
+                    "type": "integer"
                 },
                 "name": {
                     "description": "The user's name.\nexample: John Doe",
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 255
+                },
+                "version": {
+                    "description": "The user's version, incremented on every change. Send it back in\nIf-Match or in this field to update the user.\nexample: 1",
+                    "type": "integer"
                 }
             }
         },
         "models.UserListResponse": {
             "type": "object",
             "properties": {
+                "next_cursor": {
+                    "description": "Cursor for the following page when paginating by cursor.\nexample: eyJzIjoiaWQiLCJpIjoxMH0",
+                    "type": "string"
+                },
                 "page": {
                     "description": "The current page number.\nexample: 1",
                     "type": "integer"
@@ -269,6 +1662,10 @@ const docTemplate = `{
                     "description": "The size of each page.\nexample: 10",
                     "type": "integer"
                 },
+                "prev_cursor": {
+                    "description": "Cursor for the preceding page when paginating by cursor.\nexample: eyJzIjoiaWQiLCJpIjoxLCJiIjp0cnVlfQ",
+                    "type": "string"
+                },
                 "total_items": {
                     "description": "The total number of users.\nexample: 100",
                     "type": "integer"
@@ -285,6 +1682,111 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "models.UserStats": {
+            "type": "object",
+            "properties": {
+                "avg_age": {
+                    "description": "The mean age.\nexample: 37.4",
+                    "type": "number"
+                },
+                "bucket_width": {
+                    "description": "The width of the histogram buckets, in years.\nexample: 10",
+                    "type": "integer"
+                },
+                "count": {
+                    "description": "The number of users.\nexample: 100",
+                    "type": "integer"
+                },
+                "histogram": {
+                    "description": "The number of users per age bracket, from the youngest to the oldest\nuser, including empty brackets in between unless there would be more\nthan 1000 brackets.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AgeBucket"
+                    }
+                },
+                "max_age": {
+                    "description": "The oldest age.\nexample: 72",
+                    "type": "integer"
+                },
+                "median_age": {
+                    "description": "The median age.\nexample: 35",
+                    "type": "number"
+                },
+                "min_age": {
+                    "description": "The youngest age.\nexample: 18",
+                    "type": "integer"
+                },
+                "percentiles": {
+                    "description": "Age percentiles keyed p25, p50, p75, p90, p95 and p99, interpolated\nbetween neighbouring ages.\nexample: {\"p25\":27,\"p50\":35,\"p75\":46.5,\"p90\":58,\"p95\":63.1,\"p99\":70.2}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
+                }
+            }
+        },
+        "problem.Details": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "description": "Detail explains this occurrence of the problem.",
+                    "type": "string",
+                    "example": "Invalid user ID"
+                },
+                "errors": {
+                    "description": "Errors lists the broken rules of a validation problem.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/problem.FieldError"
+                    }
+                },
+                "instance": {
+                    "description": "Instance is the request URI the problem occurred on.",
+                    "type": "string",
+                    "example": "/users/abc"
+                },
+                "request_id": {
+                    "description": "RequestID is the X-Request-ID of the request, to find it in the logs.",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "status": {
+                    "description": "Status is the HTTP status code of the response.",
+                    "type": "integer",
+                    "example": 400
+                },
+                "title": {
+                    "description": "Title is a short summary of the kind of problem.",
+                    "type": "string",
+                    "example": "Bad Request"
+                },
+                "type": {
+                    "description": "Type is a URI reference that identifies the kind of problem.",
+                    "type": "string",
+                    "example": "about:blank"
+                }
+            }
+        },
+        "problem.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "Field is the JSON path of the field, e.g. profile.bio.",
+                    "type": "string",
+                    "example": "name"
+                },
+                "message": {
+                    "description": "Message describes the broken rule in words.",
+                    "type": "string",
+                    "example": "is required"
+                },
+                "rule": {
+                    "description": "Rule is the name of the broken rule.",
+                    "type": "string",
+                    "example": "required"
+                }
+            }
         }
     }
 }`
@@ -1,26 +1,104 @@
 package app
 
 import (
+	"advsql/internal/config"
+	"advsql/internal/database"
+	"advsql/internal/health"
+	"advsql/internal/metrics"
+	"advsql/internal/migrations"
+	"advsql/internal/ratelimit"
 	"advsql/internal/services"
+	"advsql/internal/tracing"
 	"advsql/internal/transport"
+	"context"
+	"fmt"
 	httpSwagger "github.com/swaggo/http-swagger"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	_ "advsql/docs"
 	"github.com/gorilla/mux"
 )
 
-func Run() {
-	err := services.СreateUsersTable()
+// traceFlushTimeout bounds the export of the last spans on shutdown.
+const traceFlushTimeout = 5 * time.Second
+
+// evictInterval is how often rate limit buckets that have refilled are
+// dropped.
+const evictInterval = time.Minute
+
+// Run serves the API until the process is asked to stop, then shuts down
+// gracefully and closes the database. The health endpoints answer at once;
+// the API only once the migrations have been applied.
+func Run() error {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: config.AppConfig.TracesExporter,
+		File:     config.AppConfig.TracesFile,
+	})
 	if err != nil {
-		return
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	migrator, err := migrations.New(database.DB)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
 	}
-	r := mux.NewRouter()
 
-	transport.RegisterRoutes(r)
+	var migrated health.Flag
+	checker := health.NewChecker(config.AppConfig.ReadinessTimeout)
+	checker.Add("database", database.Ping)
+	checker.Add("migrations", migrated.Check)
 
+	if err := metrics.RegisterDB(database.DB, config.AppConfig.DBName); err != nil {
+		return fmt.Errorf("failed to register database metrics: %w", err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware("/healthz", "/readyz", "/metrics"))
+	transport.RegisterHealthRoutes(r, transport.NewHealthHandler(checker))
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	store := services.NewPostgresUserStore(database.DB)
+	timeouts := transport.Timeouts{
+		Default: config.AppConfig.RequestTimeout,
+		Routes:  config.AppConfig.RouteTimeouts,
+	}
+	limiter := ratelimit.NewLimiter(config.AppConfig.RateLimit, config.AppConfig.RouteRateLimits, config.AppConfig.RateLimitMaxBuckets)
+	handler := transport.NewUserHandler(store, timeouts)
+	handler.Use(
+		transport.RequireStarted(&migrated),
+		transport.RateLimit(limiter, transport.ClientKey{
+			By:                config.AppConfig.RateLimitKey,
+			TrustForwardedFor: config.AppConfig.TrustForwardedFor,
+		}),
+	)
+	transport.RegisterRoutes(r, handler)
+
+	srv := newServer(&http.Server{
+		Addr:              ":8080",
+		Handler:           r,
+		ReadHeaderTimeout: config.AppConfig.ReadHeaderTimeout,
+		ReadTimeout:       config.AppConfig.ReadTimeout,
+		WriteTimeout:      config.AppConfig.WriteTimeout,
+		IdleTimeout:       config.AppConfig.IdleTimeout,
+	}, config.AppConfig.ShutdownTimeout)
+	srv.goWorker(func(ctx context.Context) {
+		limiter.Run(ctx, evictInterval)
+	})
+	srv.onClose(database.Close)
+	srv.onClose(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
+	return srv.run(func(ctx context.Context) error {
+		if err := migrator.Up(ctx); err != nil {
+			return fmt.Errorf("failed to apply migrations: %w", err)
+		}
+		slog.InfoContext(ctx, "Migrations completed")
+		migrated.Set()
+		return nil
+	})
 }
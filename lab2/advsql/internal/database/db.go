@@ -2,14 +2,20 @@ package database
 
 import (
 	"advsql/internal/config"
+	"advsql/internal/tracing"
+	"context"
 	"database/sql"
 	"fmt"
-	_ "github.com/lib/pq"
-	"log"
+	"github.com/lib/pq"
+	"log/slog"
+	"time"
 )
 
 var DB *sql.DB
 
+// connectTimeout bounds the first ping of ConnectDB.
+const connectTimeout = 5 * time.Second
+
 func ConnectDB() error {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		config.AppConfig.DBHost,
@@ -18,12 +24,36 @@ func ConnectDB() error {
 		config.AppConfig.DBName,
 		config.AppConfig.DBPort,
 	)
-	db, err := sql.Open("postgres", dsn)
+	connector, err := pq.NewConnector(dsn)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	db := sql.OpenDB(tracing.WrapConnector(connector))
+
+	// sql.OpenDB does not connect, so make sure the database is reachable
+	// before claiming success.
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
 
 	DB = db
-	log.Println("Successfully connected to the database.")
+	slog.Info("Connected to the database", "host", config.AppConfig.DBHost, "name", config.AppConfig.DBName)
 	return nil
 }
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	return DB.PingContext(ctx)
+}
+
+// Close closes the connection pool. Queries in progress are allowed to
+// finish.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Close()
+}
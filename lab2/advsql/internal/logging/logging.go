@@ -0,0 +1,73 @@
+// Package logging sets up structured JSON logging with log/slog. Records
+// logged with a request's context carry its request ID and trace, and
+// attributes that may hold secrets are redacted.
+package logging
+
+import (
+	"advsql/internal/audit"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redacted replaces the value of a sensitive attribute.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys, or key suffixes such as db_password,
+// whose values are never logged.
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie", "dsn"}
+
+// New returns a logger that writes JSON records at level and above to w.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})})
+}
+
+// Setup makes a logger from New the default, which the log package then
+// writes through as well.
+func Setup(w io.Writer, level slog.Leveler) {
+	slog.SetDefault(New(w, level))
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.HasSuffix(key, sensitive) {
+			return slog.String(attr.Key, Redacted)
+		}
+	}
+	return attr
+}
+
+// contextHandler adds the request ID and actor from the audit information
+// in a record's context, and the IDs of the span it carries.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	info := audit.FromContext(ctx)
+	if info.RequestID != "" {
+		record.AddAttrs(slog.String("request_id", info.RequestID))
+	}
+	if info.Actor != "" {
+		record.AddAttrs(slog.String("actor", info.Actor))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
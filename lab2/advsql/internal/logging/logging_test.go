@@ -0,0 +1,73 @@
+package logging_test
+
+import (
+	"advsql/internal/audit"
+	"advsql/internal/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoggerAddsRequestAndRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, slog.LevelInfo)
+	ctx := audit.NewContext(context.Background(), audit.Info{Actor: "alice", RequestID: "req-1"})
+
+	logger.DebugContext(ctx, "Not logged")
+	logger.With("api_token", "abc").InfoContext(ctx, "Connected", "db_password", "hunter2", "user_id", 7)
+	logger.Info("No request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Неверное число записей: получили %d, ожидали 2:\n%s", len(lines), buf.String())
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("Запись не в формате JSON: %v", err)
+	}
+	delete(got, "time")
+	want := map[string]any{
+		"level":       "INFO",
+		"msg":         "Connected",
+		"api_token":   logging.Redacted,
+		"db_password": logging.Redacted,
+		"user_id":     float64(7),
+		"request_id":  "req-1",
+		"actor":       "alice",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Неверная запись: получили %v, ожидали %v", got, want)
+	}
+
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("Запись без запроса содержит request_id: %s", lines[1])
+	}
+}
+
+func TestLoggerAddsTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, slog.LevelInfo)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	logger.InfoContext(ctx, "Traced")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Запись не в формате JSON: %v", err)
+	}
+	if got["trace_id"] != traceID.String() || got["span_id"] != spanID.String() {
+		t.Errorf("Неверный след: trace_id=%v span_id=%v", got["trace_id"], got["span_id"])
+	}
+}
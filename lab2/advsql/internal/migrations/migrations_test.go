@@ -0,0 +1,121 @@
+package migrations_test
+
+import (
+	"advsql/internal/migrations"
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_add_index.up.sql":          {Data: []byte("CREATE INDEX users_age_idx ON users (age);")},
+		"sql/0002_add_index.down.sql":        {Data: []byte("DROP INDEX users_age_idx;")},
+		"sql/0001_create_users_table.up.sql": {Data: []byte("CREATE TABLE users ();")},
+	}
+
+	list, err := migrations.Load(fsys)
+	if err != nil {
+		t.Fatalf("Загрузка миграций завершилась с ошибкой: %v", err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[1].Version != 2 {
+		t.Fatalf("Неверный порядок миграций: %+v", list)
+	}
+	if list[1].Name != "add_index" || list[1].Down == "" {
+		t.Errorf("Неверная миграция: %+v", list[1])
+	}
+}
+
+func TestLoadRejectsBadNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/create_users.up.sql": {Data: []byte("CREATE TABLE users ();")},
+	}
+	if _, err := migrations.Load(fsys); err == nil {
+		t.Error("Ожидалась ошибка для файла без номера версии")
+	}
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Не удалось подключиться к sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS users`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(1, "create_users_table").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS version`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(2, "add_users_version").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(3, "add_users_deleted_at").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS user_audit`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(4, "create_user_audit").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE OR REPLACE FUNCTION users_audit`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(5, "audit_bulk_loads").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Применение миграций завершилось с ошибкой: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestUpSkipsAppliedMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Не удалось подключиться к sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()).AddRow(3, time.Now()).AddRow(4, time.Now()).AddRow(5, time.Now()))
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Применение миграций завершилось с ошибкой: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
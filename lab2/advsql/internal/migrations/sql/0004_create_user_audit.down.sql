@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_audit ON users;
+DROP FUNCTION IF EXISTS users_audit();
+DROP TABLE IF EXISTS user_audit;
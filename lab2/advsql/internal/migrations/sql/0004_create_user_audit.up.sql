@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    before JSONB,
+    after JSONB,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, id);
+CREATE INDEX IF NOT EXISTS user_audit_created_at_idx ON user_audit (created_at);
+
+-- Every write to users is recorded by this trigger, so the entry commits or
+-- rolls back with the change itself. The store tags the transaction with
+-- the actor and request ID through the audit.* settings.
+CREATE OR REPLACE FUNCTION users_audit() RETURNS trigger AS $$
+DECLARE
+    audit_action TEXT;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        audit_action := 'create';
+        new_row := to_jsonb(NEW);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        audit_action := 'delete';
+        old_row := to_jsonb(OLD);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        audit_action := 'restore';
+        new_row := to_jsonb(NEW);
+    ELSE
+        audit_action := 'update';
+        old_row := to_jsonb(OLD);
+        new_row := to_jsonb(NEW);
+    END IF;
+
+    INSERT INTO user_audit (user_id, action, before, after, actor, request_id)
+    VALUES (NEW.id, audit_action, old_row, new_row,
+            coalesce(current_setting('audit.actor', true), ''),
+            coalesce(current_setting('audit.request_id', true), ''));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_audit ON users;
+CREATE TRIGGER users_audit AFTER INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_audit();
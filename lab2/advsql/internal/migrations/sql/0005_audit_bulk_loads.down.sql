@@ -0,0 +1,32 @@
+DROP TRIGGER IF EXISTS users_audit ON users;
+CREATE TRIGGER users_audit AFTER INSERT OR UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION users_audit();
+
+CREATE OR REPLACE FUNCTION users_audit() RETURNS trigger AS $$
+DECLARE
+    audit_action TEXT;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        audit_action := 'create';
+        new_row := to_jsonb(NEW);
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        audit_action := 'delete';
+        old_row := to_jsonb(OLD);
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        audit_action := 'restore';
+        new_row := to_jsonb(NEW);
+    ELSE
+        audit_action := 'update';
+        old_row := to_jsonb(OLD);
+        new_row := to_jsonb(NEW);
+    END IF;
+
+    INSERT INTO user_audit (user_id, action, before, after, actor, request_id)
+    VALUES (NEW.id, audit_action, old_row, new_row,
+            coalesce(current_setting('audit.actor', true), ''),
+            coalesce(current_setting('audit.request_id', true), ''));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEntry records one change to a user.
+// swagger:model
+type AuditEntry struct {
+	// The entry's ID.
+	// example: 1
+	ID int64 `json:"id"`
+	// The ID of the changed user, or 0 for a bulk_load entry.
+	// example: 1
+	UserID int `json:"user_id"`
+	// One of create, update, delete, restore or bulk_load.
+	// example: update
+	Action string `json:"action"`
+	// The user before the change. Not set for create and bulk_load.
+	Before json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	// The user after the change. Not set for delete. For bulk_load, the
+	// number of users loaded, e.g. {"inserted": 1000}.
+	After json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	// The claimed actor: who the client said made the change, from the
+	// X-Actor request header. The header is not authenticated.
+	// example: alice
+	Actor string `json:"actor"`
+	// The ID of the request that made the change.
+	// example: 4bf92f3577b34da6a3ce929d0e0e4736
+	RequestID string `json:"request_id"`
+	// When the change was made.
+	// example: 2024-01-02T15:04:05Z
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditListResponse represents a paginated list of audit entries, newest
+// first.
+// swagger:model
+type AuditListResponse struct {
+	// The list of entries.
+	Entries []AuditEntry `json:"entries"`
+	// The total number of matching entries.
+	// example: 100
+	TotalItems int `json:"total_items"`
+	// The current page number.
+	// example: 1
+	Page int `json:"page"`
+	// The size of each page.
+	// example: 10
+	PageSize int `json:"page_size"`
+	// The total number of pages.
+	// example: 10
+	TotalPages int `json:"total_pages"`
+}
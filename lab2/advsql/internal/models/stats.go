@@ -0,0 +1,46 @@
+package models
+
+// AgeBucket counts the users whose age falls in [From, To).
+// swagger:model
+type AgeBucket struct {
+	// The lowest age in the bucket.
+	// example: 20
+	From int `json:"from"`
+	// The lowest age of the next bucket.
+	// example: 30
+	To int `json:"to"`
+	// The number of users in the bucket.
+	// example: 12
+	Count int `json:"count"`
+}
+
+// UserStats summarises the ages of the users matching a filter.
+// swagger:model
+type UserStats struct {
+	// The number of users.
+	// example: 100
+	Count int `json:"count"`
+	// The youngest age.
+	// example: 18
+	MinAge int `json:"min_age"`
+	// The oldest age.
+	// example: 72
+	MaxAge int `json:"max_age"`
+	// The mean age.
+	// example: 37.4
+	AvgAge float64 `json:"avg_age"`
+	// The median age.
+	// example: 35
+	MedianAge float64 `json:"median_age"`
+	// Age percentiles keyed p25, p50, p75, p90, p95 and p99, interpolated
+	// between neighbouring ages.
+	// example: {"p25":27,"p50":35,"p75":46.5,"p90":58,"p95":63.1,"p99":70.2}
+	Percentiles map[string]float64 `json:"percentiles"`
+	// The width of the histogram buckets, in years.
+	// example: 10
+	BucketWidth int `json:"bucket_width"`
+	// The number of users per age bracket, from the youngest to the oldest
+	// user, including empty brackets in between unless there would be more
+	// than 1000 brackets.
+	Histogram []AgeBucket `json:"histogram"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // User represents a user in the system.
 // swagger:model
 type User struct {
@@ -8,10 +10,17 @@ type User struct {
 	ID int `json:"id"`
 	// The user's name.
 	// example: John Doe
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required,max=255"`
 	// The user's age.
 	// example: 30
-	Age int `json:"age"`
+	Age int `json:"age" validate:"gte=0,lte=150"`
+	// The user's version, incremented on every change. Send it back in
+	// If-Match or in this field to update the user.
+	// example: 1
+	Version int `json:"version"`
+	// When the user was deleted. Only set on users in the trash.
+	// example: 2024-01-02T15:04:05Z
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 // UserListResponse represents a paginated list of users.
@@ -31,4 +40,113 @@ type UserListResponse struct {
 	// The total number of pages.
 	// example: 10
 	TotalPages int `json:"total_pages"`
+	// Cursor for the following page when paginating by cursor.
+	// example: eyJzIjoiaWQiLCJpIjoxMH0
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Cursor for the preceding page when paginating by cursor.
+	// example: eyJzIjoiaWQiLCJpIjoxLCJiIjp0cnVlfQ
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// BulkCreateResult is the outcome of one element of a non-atomic bulk create.
+// swagger:model
+type BulkCreateResult struct {
+	// The position of the user in the request body.
+	// example: 0
+	Index int `json:"index"`
+	// The HTTP status of this element.
+	// example: 201
+	Status int `json:"status"`
+	// The ID of the created user.
+	// example: 1
+	ID int `json:"id,omitempty"`
+	// Why the user was not created.
+	// example: a user with name "John Doe" already exists
+	Error string `json:"error,omitempty"`
+}
+
+// BulkCreateResponse reports the per-user results of a non-atomic bulk create.
+// swagger:model
+type BulkCreateResponse struct {
+	// The result of every user, in request order.
+	Results []BulkCreateResult `json:"results"`
+	// The number of users created.
+	// example: 9
+	Created int `json:"created"`
+	// The number of users rejected.
+	// example: 1
+	Failed int `json:"failed"`
+}
+
+// BulkLoadResponse reports the outcome of a streamed bulk load.
+// swagger:model
+type BulkLoadResponse struct {
+	// The number of users inserted.
+	// example: 100000
+	Inserted int64 `json:"inserted"`
+	// How long the load took, in milliseconds.
+	// example: 850
+	DurationMS int64 `json:"duration_ms"`
+	// Insert throughput.
+	// example: 117647.06
+	RowsPerSecond float64 `json:"rows_per_second"`
+}
+
+// UpsertResult reports what happened to one user of an upsert.
+// swagger:model
+type UpsertResult struct {
+	// The position of the user in the request body.
+	// example: 0
+	Index int `json:"index"`
+	// Either "created" or "updated".
+	// example: created
+	Action string `json:"action"`
+	// The stored user.
+	User User `json:"user"`
+}
+
+// UpsertResponse reports the per-user results of an upsert.
+// swagger:model
+type UpsertResponse struct {
+	// The result of every user, in request order.
+	Results []UpsertResult `json:"results"`
+	// The number of users created.
+	// example: 3
+	Created int `json:"created"`
+	// The number of users updated.
+	// example: 7
+	Updated int `json:"updated"`
+}
+
+// ImportError describes a problem with one line of an imported CSV file.
+// swagger:model
+type ImportError struct {
+	// The line of the file, counting the header as line 1.
+	// example: 3
+	Line int `json:"line"`
+	// The CSV column the problem was found in, if any.
+	// example: age
+	Column string `json:"column,omitempty"`
+	// What is wrong with the line.
+	// example: must be a whole number
+	Message string `json:"message"`
+}
+
+// ImportReport reports the outcome of a CSV import.
+// swagger:model
+type ImportReport struct {
+	// Whether the file was only validated.
+	// example: false
+	DryRun bool `json:"dry_run"`
+	// The number of data rows in the file.
+	// example: 10
+	Rows int `json:"rows"`
+	// The number of rows that passed validation.
+	// example: 9
+	Valid int `json:"valid"`
+	// The number of users created.
+	// example: 9
+	Imported int `json:"imported"`
+	// The problems found, ordered by line.
+	Errors []ImportError `json:"errors"`
 }
@@ -0,0 +1,127 @@
+// Package problem writes error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ContentType is the media type of a problem details response.
+const ContentType = "application/problem+json"
+
+// ValidationType identifies a request whose data broke validation rules.
+// Other problems use about:blank, and their title is the status text.
+const ValidationType = "/problems/validation"
+
+// Details is an RFC 7807 problem details object.
+type Details struct {
+	// Type is a URI reference that identifies the kind of problem.
+	Type string `json:"type" example:"about:blank"`
+	// Title is a short summary of the kind of problem.
+	Title string `json:"title" example:"Bad Request"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status" example:"400"`
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty" example:"Invalid user ID"`
+	// Instance is the request URI the problem occurred on.
+	Instance string `json:"instance,omitempty" example:"/users/abc"`
+	// RequestID is the X-Request-ID of the request, to find it in the logs.
+	RequestID string `json:"request_id,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	// Errors lists the broken rules of a validation problem.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation rule broken by one field of the request data.
+type FieldError struct {
+	// Field is the JSON path of the field, e.g. profile.bio.
+	Field string `json:"field" example:"name"`
+	// Rule is the name of the broken rule.
+	Rule string `json:"rule" example:"required"`
+	// Message describes the broken rule in words.
+	Message string `json:"message" example:"is required"`
+}
+
+// New returns the details of a problem with status that occurred on r.
+func New(r *http.Request, status int, detail string) *Details {
+	return &Details{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+	}
+}
+
+// Write responds to r with status and the problem details.
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	WriteDetails(w, r, New(r, status, detail))
+}
+
+// WriteDetails responds to r with p. The request ID is taken from the
+// response headers, where withAuditInfo echoes it, or else from r.
+func WriteDetails(w http.ResponseWriter, r *http.Request, p *Details) {
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get("X-Request-ID")
+	}
+	if p.RequestID == "" {
+		p.RequestID = r.Header.Get("X-Request-ID")
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Validation responds to r with status and a field error for every rule in
+// errs.
+func Validation(w http.ResponseWriter, r *http.Request, status int, errs validator.ValidationErrors) {
+	ValidationAt(w, r, status, "", errs)
+}
+
+// ValidationAt is Validation for one item of a batch: path, such as [2],
+// is prepended to the field paths.
+func ValidationAt(w http.ResponseWriter, r *http.Request, status int, path string, errs validator.ValidationErrors) {
+	p := New(r, status, "The data failed validation")
+	p.Type = ValidationType
+	p.Title = "Validation failed"
+	for _, err := range errs {
+		field := Field(err)
+		if path != "" {
+			field = path + "." + field
+		}
+		p.Errors = append(p.Errors, FieldError{Field: field, Rule: err.Tag(), Message: Message(err)})
+	}
+	WriteDetails(w, r, p)
+}
+
+// Field returns the path of the field err is about, without the name of the
+// validated struct. Paths into a validated list start with the item's
+// index, e.g. [1].name.
+func Field(err validator.FieldError) string {
+	namespace := err.Namespace()
+	if strings.HasPrefix(namespace, "[") {
+		return namespace
+	}
+	_, field, _ := strings.Cut(namespace, ".")
+	return field
+}
+
+// Message describes a broken validation rule in words.
+func Message(err validator.FieldError) string {
+	switch err.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", err.Param())
+	case "gte":
+		return fmt.Sprintf("must be at least %s", err.Param())
+	case "lte":
+		return fmt.Sprintf("must be at most %s", err.Param())
+	default:
+		return fmt.Sprintf("failed the %s rule", err.Tag())
+	}
+}
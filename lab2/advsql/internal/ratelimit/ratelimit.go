@@ -0,0 +1,210 @@
+// Package ratelimit limits how often each client may call each route with
+// token buckets kept in memory.
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Period, in bursts of up to Requests. The zero
+// Limit allows everything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit written as "requests/period", e.g. "10/1m".
+// "" and "none" mean no limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "none" {
+		return Limit{}, nil
+	}
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected requests/period", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive number", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: period must be a positive duration", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// Unlimited reports whether l allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// String formats l as ParseLimit reads it.
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "none"
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// perSecond is the rate at which the bucket refills.
+func (l Limit) perSecond() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Decision is the outcome of a request against its limit.
+type Decision struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is the number of requests the client may still make at
+	// once.
+	Remaining int
+	// RetryAfter is how long a refused client has to wait for the next
+	// request to be allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	key    string
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+now.Sub(b.last).Seconds()*b.limit.perSecond())
+	b.last = now
+}
+
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.perSecond() >= float64(b.limit.Requests)
+}
+
+// Limiter keeps a bucket per route and client. A bucket that has refilled
+// is no different from a new one, so Evict drops those. Clients can name
+// themselves, so the number of buckets is capped as well: past the cap, a
+// new bucket replaces the least recently used one.
+type Limiter struct {
+	fallback   Limit
+	routes     map[string]Limit
+	maxBuckets int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent holds the buckets, the most recently used first.
+	recent *list.List
+}
+
+// NewLimiter returns a limiter applying routes[route] to each route, keyed
+// by "METHOD /path/template", and fallback to the routes without an entry.
+// It keeps at most maxBuckets buckets, or any number if maxBuckets is zero.
+func NewLimiter(fallback Limit, routes map[string]Limit, maxBuckets int) *Limiter {
+	return &Limiter{
+		fallback:   fallback,
+		routes:     routes,
+		maxBuckets: maxBuckets,
+		buckets:    map[string]*list.Element{},
+		recent:     list.New(),
+	}
+}
+
+// Limit returns the limit of route.
+func (l *Limiter) Limit(route string) Limit {
+	if limit, ok := l.routes[route]; ok {
+		return limit
+	}
+	return l.fallback
+}
+
+// Allow takes a token from client's bucket for route at time now, if there
+// is one.
+func (l *Limiter) Allow(now time.Time, route, client string) Decision {
+	limit := l.Limit(route)
+	if limit.Unlimited() {
+		return Decision{Allowed: true, Limit: limit}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := route + " " + client
+	e, ok := l.buckets[key]
+	if ok {
+		l.recent.MoveToFront(e)
+	} else {
+		if l.maxBuckets > 0 && len(l.buckets) >= l.maxBuckets {
+			l.remove(l.recent.Back())
+		}
+		e = l.recent.PushFront(&bucket{key: key, limit: limit, tokens: float64(limit.Requests), last: now})
+		l.buckets[key] = e
+	}
+	b := e.Value.(*bucket)
+	b.refill(now)
+
+	d := Decision{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - b.tokens) / limit.perSecond())
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((float64(limit.Requests) - b.tokens) / limit.perSecond())
+	return d
+}
+
+// Evict drops the buckets that have refilled by now and returns how many
+// there were.
+func (l *Limiter) Evict(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	evicted := 0
+	for _, e := range l.buckets {
+		if e.Value.(*bucket).full(now) {
+			l.remove(e)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// remove drops the bucket in e. It must be called with l.mu held.
+func (l *Limiter) remove(e *list.Element) {
+	delete(l.buckets, e.Value.(*bucket).key)
+	l.recent.Remove(e)
+}
+
+// Len returns the number of buckets kept.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Run evicts refilled buckets every interval until ctx is done.
+func (l *Limiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.Evict(now)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"advsql/internal/audit"
+	"advsql/internal/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditFilter narrows an audit listing. Zero fields match everything.
+type AuditFilter struct {
+	UserID    int
+	Action    string
+	Actor     string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f AuditFilter) matches(entry models.AuditEntry) bool {
+	return (f.UserID == 0 || entry.UserID == f.UserID) &&
+		(f.Action == "" || entry.Action == f.Action) &&
+		(f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.RequestID == "" || entry.RequestID == f.RequestID) &&
+		(f.Since.IsZero() || !entry.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.CreatedAt.Before(f.Until))
+}
+
+// beginTx starts a transaction whose writes to users are recorded by the
+// users_audit trigger under the actor and request ID of ctx. The settings
+// are local to the transaction, so they never leak to pooled connections.
+func (s *PostgresUserStore) beginTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	info := audit.FromContext(ctx)
+	_, err = tx.ExecContext(ctx, "SELECT set_config('audit.actor', $1, true), set_config('audit.request_id', $2, true)", info.Actor, info.RequestID)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to set audit context: %w", err)
+	}
+	return tx, nil
+}
+
+// beginBulkTx is beginTx for a bulk load. The users_audit trigger skips
+// the rows it inserts, which recordBulkLoad audits in one statement.
+func (s *PostgresUserStore) beginBulkTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT set_config('audit.bulk', 'on', true)"); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to set audit context: %w", err)
+	}
+	return tx, nil
+}
+
+// recordBulkLoad adds a create entry for every user a bulk load inserted in
+// tx, the rows past afterID written by tx itself, and then the bulk_load
+// entry summing them up.
+func recordBulkLoad(ctx context.Context, tx *sql.Tx, afterID int, inserted int64) error {
+	info := audit.FromContext(ctx)
+	_, err := tx.ExecContext(ctx, `
+   INSERT INTO user_audit (user_id, action, after, actor, request_id)
+   SELECT id, $1, to_jsonb(users), $2, $3 FROM users
+   WHERE id > $4 AND xmin = pg_current_xact_id()::xid
+   ORDER BY id
+   `, audit.ActionCreate, info.Actor, info.RequestID, afterID)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entries: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO user_audit (user_id, action, after, actor, request_id) VALUES (0, $1, jsonb_build_object('inserted', $2::bigint), $3, $4)",
+		audit.ActionBulkLoad, inserted, info.Actor, info.RequestID)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+func auditFilters(filter AuditFilter) ([]string, []interface{}) {
+	var whereClauses []string
+	var params []interface{}
+
+	add := func(clause string, value interface{}) {
+		params = append(params, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(params)))
+	}
+	if filter.UserID != 0 {
+		add("user_id = $%d", filter.UserID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.RequestID != "" {
+		add("request_id = $%d", filter.RequestID)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+	return whereClauses, params
+}
+
+func (s *PostgresUserStore) ListAudit(ctx context.Context, filter AuditFilter, page, pageSize int) ([]models.AuditEntry, int, error) {
+	whereClauses, params := auditFilters(filter)
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var totalCount int
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM user_audit %s", whereClause), params...).Scan(&totalCount)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	query := fmt.Sprintf("SELECT id, user_id, action, before, after, actor, request_id, created_at FROM user_audit %s ORDER BY id DESC LIMIT $%d OFFSET $%d",
+		whereClause, len(params)+1, len(params)+2)
+	rows, err := s.db.QueryContext(ctx, query, append(params, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Action, &before, &after, &entry.Actor, &entry.RequestID, &entry.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+	}
+	return entries, totalCount, nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"advsql/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// does not belong to the requested listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the decoded form of an opaque keyset pagination cursor. It holds
+// the sort keys of the row the next page should seek past; fields that are
+// not part of Sort are left empty.
+type Cursor struct {
+	Sort     string `json:"s"`
+	ID       int    `json:"i"`
+	Name     string `json:"n,omitempty"`
+	Age      int    `json:"a,omitempty"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// EncodeCursor serialises c into an opaque, URL-safe token.
+func EncodeCursor(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a token produced by EncodeCursor.
+func DecodeCursor(token string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if sort, err := ParseSort(c.Sort); err != nil || sort.String() != c.Sort || c.ID <= 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// resolveCursor decodes token for the normalised sort. A nil cursor means the
+// listing starts from the beginning.
+func resolveCursor(sort Sort, token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	c, err := DecodeCursor(token)
+	if err != nil {
+		return nil, err
+	}
+	if c.Sort != sort.String() {
+		return nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidCursor, c.Sort)
+	}
+	return &c, nil
+}
+
+// newCursor returns a cursor positioned at user.
+func newCursor(sort Sort, user models.User, backward bool) Cursor {
+	c := Cursor{Sort: sort.String(), ID: user.ID, Backward: backward}
+	for _, key := range sort {
+		switch key.Field {
+		case "name":
+			c.Name = user.Name
+		case "age":
+			c.Age = user.Age
+		}
+	}
+	return c
+}
+
+// value returns the cursor's value for a sort field.
+func (c Cursor) value(field string) interface{} {
+	switch field {
+	case "name":
+		return c.Name
+	case "age":
+		return c.Age
+	default:
+		return c.ID
+	}
+}
+
+// keysetPage turns rows read in seek order, with one extra row fetched to
+// detect whether more remain, into a page in display order along with the
+// cursors of the following and preceding pages.
+func keysetPage(users []models.User, pageSize int, sort Sort, after *Cursor) ([]models.User, string, string) {
+	backward := after != nil && after.Backward
+
+	hasMore := len(users) > pageSize
+	if hasMore {
+		users = users[:pageSize]
+	}
+	if backward {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
+	if len(users) == 0 {
+		return users, "", ""
+	}
+
+	hasNext, hasPrev := hasMore, after != nil
+	if backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	var next, prev string
+	if hasNext {
+		next = EncodeCursor(newCursor(sort, users[len(users)-1], false))
+	}
+	if hasPrev {
+		prev = EncodeCursor(newCursor(sort, users[0], true))
+	}
+	return users, next, prev
+}
+
+// seekClause returns the condition that selects the rows following c in seek
+// order and appends its arguments to params. When every key runs the same
+// way it is a row comparison, which an index on the keys can serve; mixed
+// directions need the expanded form.
+func seekClause(sort Sort, c *Cursor, backward bool, params []interface{}) (string, []interface{}) {
+	op := func(key SortKey) string {
+		if key.Desc != backward {
+			return "<"
+		}
+		return ">"
+	}
+
+	columns := make([]string, len(sort))
+	placeholders := make([]string, len(sort))
+	for i, key := range sort {
+		params = append(params, c.value(key.Field))
+		columns[i] = key.Field
+		placeholders[i] = fmt.Sprintf("$%d", len(params))
+	}
+
+	if len(sort) == 1 {
+		return fmt.Sprintf("%s %s %s", columns[0], op(sort[0]), placeholders[0]), params
+	}
+	if !slices.ContainsFunc(sort, func(k SortKey) bool { return k.Desc != sort[0].Desc }) {
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), op(sort[0]), strings.Join(placeholders, ", ")), params
+	}
+
+	// (a > $1 OR (a = $1 AND (b < $2 OR (b = $2 AND (id > $3)))))
+	clause := ""
+	for i := len(sort) - 1; i >= 0; i-- {
+		cond := fmt.Sprintf("%s %s %s", columns[i], op(sort[i]), placeholders[i])
+		if clause != "" {
+			cond = fmt.Sprintf("%s OR (%s = %s AND %s)", cond, columns[i], placeholders[i], clause)
+		}
+		clause = "(" + cond + ")"
+	}
+	return clause, params
+}
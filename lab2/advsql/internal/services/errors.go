@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Domain errors returned by UserStore implementations. Callers match them
+// with errors.Is; the driver error, if any, stays in the wrap chain.
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid data")
+	// ErrVersionMismatch means the user was updated since the caller read
+	// it, so the caller's write would silently overwrite that change.
+	ErrVersionMismatch = errors.New("user version mismatch")
+	// ErrNotDeleted means a restore was requested for a user that is not
+	// in the trash.
+	ErrNotDeleted = errors.New("user is not deleted")
+)
+
+// ConstraintError reports a violated database constraint. It unwraps to
+// ErrConflict or ErrInvalid, and Message is safe to show to clients.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Message    string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *ConstraintError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// constraintMessages holds client-facing messages for known constraints.
+var constraintMessages = map[string]string{
+	"users_name_key": "a user with this name already exists",
+}
+
+// duplicateName is the error reported when a name is already taken.
+func duplicateName(name string) error {
+	return &ConstraintError{
+		Kind:       ErrConflict,
+		Constraint: "users_name_key",
+		Message:    fmt.Sprintf("a user with name %q already exists", name),
+	}
+}
+
+// translateError converts Postgres integrity errors into a ConstraintError.
+// Other errors are returned unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	var kind error
+	switch pqErr.Code {
+	case "23505", "23503": // unique_violation, foreign_key_violation
+		kind = ErrConflict
+	case "23514", "23502", "22001": // check_violation, not_null_violation, string_data_right_truncation
+		kind = ErrInvalid
+	default:
+		return err
+	}
+
+	message, ok := constraintMessages[pqErr.Constraint]
+	if !ok {
+		message = "the request violates a data constraint"
+		if pqErr.Constraint != "" {
+			message = fmt.Sprintf("the request violates constraint %q", pqErr.Constraint)
+		}
+	}
+	return &ConstraintError{Kind: kind, Constraint: pqErr.Constraint, Message: message, Err: err}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"advsql/internal/models"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidFields is returned by ParseFields for a field list that names an
+// unknown field.
+var ErrInvalidFields = errors.New("invalid fields")
+
+// UserFields lists the fields a read can be limited to. Each one is also the
+// name of its column and of its JSON member.
+var UserFields = []string{"id", "name", "age", "version", "deleted_at"}
+
+// Fields limits the columns a read loads. Nil loads every column. Columns
+// that were not loaded are left at their zero value.
+type Fields []string
+
+// ParseFields parses a comma separated list of field names such as
+// "id,name". An empty list yields nil.
+func ParseFields(expr string) (Fields, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	var fields Fields
+	for _, part := range strings.Split(expr, ",") {
+		field := strings.TrimSpace(part)
+		if !slices.Contains(UserFields, field) {
+			return nil, fmt.Errorf("%w: unknown field %q, expected one of %s", ErrInvalidFields, field, strings.Join(UserFields, ", "))
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// columns returns the columns to select for f, in table order, adding the
+// required columns a store needs for its own bookkeeping.
+func (f Fields) columns(required ...string) []string {
+	if f == nil {
+		return UserFields
+	}
+	var columns []string
+	for _, field := range UserFields {
+		if slices.Contains(f, field) || slices.Contains(required, field) {
+			columns = append(columns, field)
+		}
+	}
+	return columns
+}
+
+// scanTargets returns the destinations of columns in user.
+func scanTargets(user *models.User, columns []string) []interface{} {
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			targets[i] = &user.ID
+		case "name":
+			targets[i] = &user.Name
+		case "age":
+			targets[i] = &user.Age
+		case "version":
+			targets[i] = &user.Version
+		case "deleted_at":
+			targets[i] = &user.DeletedAt
+		}
+	}
+	return targets
+}
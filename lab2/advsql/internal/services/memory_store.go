@@ -0,0 +1,470 @@
+package services
+
+import (
+	"advsql/internal/audit"
+	"advsql/internal/models"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore is a thread-safe, in-process UserStore. It mirrors the
+// behaviour of PostgresUserStore, including the unique name constraint, and
+// is meant for tests and local runs without a database.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]models.User
+	nextID int
+	audit  []models.AuditEntry
+}
+
+// NewMemoryUserStore returns an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[int]models.User{}, nextID: 1}
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, users []models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check the whole batch first so a failure leaves the store untouched,
+	// like the rolled back transaction in PostgresUserStore.
+	names := map[string]bool{}
+	for _, u := range s.users {
+		names[u.Name] = true
+	}
+	for _, user := range users {
+		if names[user.Name] {
+			return duplicateName(user.Name)
+		}
+		names[user.Name] = true
+	}
+
+	for _, user := range users {
+		user.ID = s.nextID
+		user.Version = 1
+		user.DeletedAt = nil
+		s.nextID++
+		s.users[user.ID] = user
+		s.record(ctx, audit.ActionCreate, nil, &user)
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) CreateEach(ctx context.Context, users []models.User) ([]CreateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := map[string]bool{}
+	for _, u := range s.users {
+		names[u.Name] = true
+	}
+
+	results := make([]CreateResult, len(users))
+	for i, user := range users {
+		if names[user.Name] {
+			results[i].Err = duplicateName(user.Name)
+			continue
+		}
+		names[user.Name] = true
+		user.ID = s.nextID
+		user.Version = 1
+		user.DeletedAt = nil
+		s.nextID++
+		s.users[user.ID] = user
+		s.record(ctx, audit.ActionCreate, nil, &user)
+		results[i].ID = user.ID
+	}
+	return results, nil
+}
+
+// BulkLoad reads the whole source before it takes the lock, so a slow
+// upload does not hold up every other request to the store.
+func (s *MemoryUserStore) BulkLoad(ctx context.Context, src UserSource) (int64, error) {
+	var loaded []models.User
+	for {
+		user, err := src()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		loaded = append(loaded, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := map[string]bool{}
+	for _, u := range s.users {
+		names[u.Name] = true
+	}
+	for _, user := range loaded {
+		if names[user.Name] {
+			return 0, duplicateName(user.Name)
+		}
+		names[user.Name] = true
+	}
+
+	for _, user := range loaded {
+		user.ID = s.nextID
+		user.Version = 1
+		user.DeletedAt = nil
+		s.nextID++
+		s.users[user.ID] = user
+		s.record(ctx, audit.ActionCreate, nil, &user)
+	}
+	s.recordBulkLoad(ctx, int64(len(loaded)))
+	return int64(len(loaded)), nil
+}
+
+func (s *MemoryUserStore) Upsert(ctx context.Context, users []models.User) ([]UpsertResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byName := map[string]int{}
+	for id, u := range s.users {
+		byName[u.Name] = id
+	}
+
+	results := make([]UpsertResult, len(users))
+	for i, user := range users {
+		user.DeletedAt = nil
+		if id, ok := byName[user.Name]; ok {
+			current := s.users[id]
+			user.ID = id
+			user.Version = current.Version + 1
+			results[i] = UpsertResult{User: user}
+			if current.DeletedAt != nil {
+				s.record(ctx, audit.ActionRestore, &current, &user)
+			} else {
+				s.record(ctx, audit.ActionUpdate, &current, &user)
+			}
+		} else {
+			user.ID = s.nextID
+			user.Version = 1
+			s.nextID++
+			byName[user.Name] = user.ID
+			results[i] = UpsertResult{User: user, Created: true}
+			s.record(ctx, audit.ActionCreate, nil, &user)
+		}
+		s.users[user.ID] = user
+	}
+	return results, nil
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, userID int, fields Fields) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) List(ctx context.Context, minAge, maxAge, page, pageSize int, sort Sort, deleted DeletedFilter, fields Fields) ([]models.User, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := s.filter(minAge, maxAge, deleted)
+	slices.SortFunc(users, userOrder(normalizeSort(sort), false))
+
+	offset := min((page-1)*pageSize, len(users))
+	end := min(offset+pageSize, len(users))
+	return slices.Clone(users[offset:end]), len(users), nil
+}
+
+func (s *MemoryUserStore) Export(ctx context.Context, minAge, maxAge int, sort Sort, sink UserSink) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	users := s.filter(minAge, maxAge, ExcludeDeleted)
+	s.mu.RUnlock()
+
+	slices.SortFunc(users, userOrder(normalizeSort(sort), false))
+	for _, user := range users {
+		if err := sink(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) Stats(ctx context.Context, minAge, maxAge int, deleted DeletedFilter, bucketWidth int) (models.UserStats, error) {
+	if err := ctx.Err(); err != nil {
+		return models.UserStats{}, err
+	}
+	s.mu.RLock()
+	users := s.filter(minAge, maxAge, deleted)
+	s.mu.RUnlock()
+
+	ages := make([]int, len(users))
+	for i, user := range users {
+		ages[i] = user.Age
+	}
+	slices.Sort(ages)
+	return ageStats(ages, bucketWidth), nil
+}
+
+func (s *MemoryUserStore) ListByCursor(ctx context.Context, minAge, maxAge, pageSize int, sort Sort, cursor string, deleted DeletedFilter, fields Fields) ([]models.User, string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", "", err
+	}
+	sort = normalizeSort(sort)
+
+	after, err := resolveCursor(sort, cursor)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	s.mu.RLock()
+	users := s.filter(minAge, maxAge, deleted)
+	s.mu.RUnlock()
+
+	order := userOrder(sort, after != nil && after.Backward)
+	slices.SortFunc(users, order)
+
+	if after != nil {
+		boundary := models.User{ID: after.ID, Name: after.Name, Age: after.Age}
+		start, _ := slices.BinarySearchFunc(users, boundary, order)
+		for start < len(users) && order(users[start], boundary) == 0 {
+			start++
+		}
+		users = users[start:]
+	}
+	if len(users) > pageSize+1 {
+		users = users[:pageSize+1]
+	}
+
+	users, next, prev := keysetPage(users, pageSize, sort, after)
+	return users, next, prev, nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, user models.User) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[user.ID]
+	if !ok || current.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	if user.Version != 0 && user.Version != current.Version {
+		return models.User{}, ErrVersionMismatch
+	}
+	for id, u := range s.users {
+		if id != user.ID && u.Name == user.Name {
+			return models.User{}, duplicateName(user.Name)
+		}
+	}
+	user.Version = current.Version + 1
+	s.users[user.ID] = user
+	s.record(ctx, audit.ActionUpdate, &current, &user)
+	return user, nil
+}
+
+func (s *MemoryUserStore) Patch(ctx context.Context, userID, version int, apply func(models.User) (models.User, error)) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[userID]
+	if !ok || current.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+	if version != 0 && version != current.Version {
+		return models.User{}, ErrVersionMismatch
+	}
+	user, err := apply(current)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.ID = userID
+	user.Version = current.Version
+	if user == current {
+		return user, nil
+	}
+	for id, u := range s.users {
+		if id != userID && u.Name == user.Name {
+			return models.User{}, duplicateName(user.Name)
+		}
+	}
+	user.Version++
+	s.users[userID] = user
+	s.record(ctx, audit.ActionUpdate, &current, &user)
+	return user, nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return ErrNotFound
+	}
+	s.record(ctx, audit.ActionDelete, &user, nil)
+	now := time.Now()
+	user.DeletedAt = &now
+	s.users[userID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) Restore(ctx context.Context, userID int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	if user.DeletedAt == nil {
+		return models.User{}, ErrNotDeleted
+	}
+	deleted := user
+	user.DeletedAt = nil
+	s.users[userID] = user
+	s.record(ctx, audit.ActionRestore, &deleted, &user)
+	return user, nil
+}
+
+func (s *MemoryUserStore) ListAudit(ctx context.Context, filter AuditFilter, page, pageSize int) ([]models.AuditEntry, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []models.AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if filter.matches(s.audit[i]) {
+			entries = append(entries, s.audit[i])
+		}
+	}
+
+	offset := min((page-1)*pageSize, len(entries))
+	end := min(offset+pageSize, len(entries))
+	return entries[offset:end], len(entries), nil
+}
+
+// record appends an entry to the audit trail. It must be called with s.mu
+// held for writing.
+func (s *MemoryUserStore) record(ctx context.Context, action string, before, after *models.User) {
+	info := audit.FromContext(ctx)
+	s.audit = append(s.audit, models.AuditEntry{
+		ID:        int64(len(s.audit) + 1),
+		UserID:    cmp.Or(before, after).ID,
+		Action:    action,
+		Before:    marshalUser(before),
+		After:     marshalUser(after),
+		Actor:     info.Actor,
+		RequestID: info.RequestID,
+		CreatedAt: time.Now(),
+	})
+}
+
+// recordBulkLoad appends the bulk_load entry of a load of inserted users.
+// It must be called with s.mu held for writing.
+func (s *MemoryUserStore) recordBulkLoad(ctx context.Context, inserted int64) {
+	info := audit.FromContext(ctx)
+	after, _ := json.Marshal(map[string]int64{"inserted": inserted})
+	s.audit = append(s.audit, models.AuditEntry{
+		ID:        int64(len(s.audit) + 1),
+		Action:    audit.ActionBulkLoad,
+		After:     after,
+		Actor:     info.Actor,
+		RequestID: info.RequestID,
+		CreatedAt: time.Now(),
+	})
+}
+
+func marshalUser(user *models.User) json.RawMessage {
+	if user == nil {
+		return nil
+	}
+	data, _ := json.Marshal(user)
+	return data
+}
+
+// filter must be called with s.mu held.
+func (s *MemoryUserStore) filter(minAge, maxAge int, deleted DeletedFilter) []models.User {
+	var users []models.User
+	for _, u := range s.users {
+		if (deleted == ExcludeDeleted && u.DeletedAt != nil) || (deleted == OnlyDeleted && u.DeletedAt == nil) {
+			continue
+		}
+		if minAge > 0 && u.Age < minAge {
+			continue
+		}
+		if maxAge > 0 && u.Age > maxAge {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// userOrder compares users by the keys of a normalised sort, optionally
+// reversed.
+func userOrder(sort Sort, reverse bool) func(a, b models.User) int {
+	return func(a, b models.User) int {
+		for _, key := range sort {
+			var c int
+			switch key.Field {
+			case "name":
+				c = cmp.Compare(a.Name, b.Name)
+			case "age":
+				c = cmp.Compare(a.Age, b.Age)
+			default:
+				c = cmp.Compare(a.ID, b.ID)
+			}
+			if key.Desc != reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidSort is returned by ParseSort for an expression that names an
+// unknown field or direction.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// SortFields lists the fields a user listing can be sorted by. Each one is
+// also the name of its column.
+var SortFields = []string{"id", "name", "age"}
+
+// SortKey is one field of a sort expression.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Sort orders a listing by one or more keys, most significant first.
+type Sort []SortKey
+
+// ParseSort parses a comma separated list of field:direction pairs such as
+// "age:desc,name:asc". The direction is asc or desc and defaults to asc.
+// The older name_asc and name_desc values are still accepted. The result
+// always ends with id so that users with equal keys keep a stable order.
+func ParseSort(expr string) (Sort, error) {
+	switch expr {
+	case "name_asc":
+		expr = "name:asc"
+	case "name_desc":
+		expr = "name:desc"
+	}
+
+	var sort Sort
+	if strings.TrimSpace(expr) == "" {
+		return normalizeSort(sort), nil
+	}
+	for _, part := range strings.Split(expr, ",") {
+		field, dir, _ := strings.Cut(strings.TrimSpace(part), ":")
+		key := SortKey{Field: strings.TrimSpace(field)}
+		if !slices.Contains(SortFields, key.Field) {
+			return nil, fmt.Errorf("%w: unknown field %q, expected one of %s", ErrInvalidSort, key.Field, strings.Join(SortFields, ", "))
+		}
+		if slices.ContainsFunc(sort, func(k SortKey) bool { return k.Field == key.Field }) {
+			return nil, fmt.Errorf("%w: field %q is listed more than once", ErrInvalidSort, key.Field)
+		}
+		switch strings.ToLower(strings.TrimSpace(dir)) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("%w: unknown direction %q for %s, expected asc or desc", ErrInvalidSort, dir, key.Field)
+		}
+		sort = append(sort, key)
+	}
+	return normalizeSort(sort), nil
+}
+
+// normalizeSort appends id to sort unless it already contains it. An empty
+// sort orders by id.
+func normalizeSort(sort Sort) Sort {
+	if slices.ContainsFunc(sort, func(k SortKey) bool { return k.Field == "id" }) {
+		return sort
+	}
+	return append(slices.Clip(sort), SortKey{Field: "id"})
+}
+
+// String formats sort in the syntax accepted by ParseSort, with every
+// direction spelled out.
+func (s Sort) String() string {
+	parts := make([]string, len(s))
+	for i, key := range s {
+		dir := "asc"
+		if key.Desc {
+			dir = "desc"
+		}
+		parts[i] = key.Field + ":" + dir
+	}
+	return strings.Join(parts, ",")
+}
+
+// orderBy returns the ORDER BY clause for sort, optionally reversed.
+func (s Sort) orderBy(reverse bool) string {
+	parts := make([]string, len(s))
+	for i, key := range s {
+		dir := "ASC"
+		if key.Desc != reverse {
+			dir = "DESC"
+		}
+		parts[i] = key.Field + " " + dir
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
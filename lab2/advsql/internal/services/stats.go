@@ -0,0 +1,137 @@
+package services
+
+import (
+	"advsql/internal/models"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// statsPercentiles are the percentiles reported by Stats.
+var statsPercentiles = []float64{0.25, 0.5, 0.75, 0.9, 0.95, 0.99}
+
+// percentileKey names a percentile in UserStats.Percentiles, e.g. p95.
+func percentileKey(p float64) string {
+	return fmt.Sprintf("p%g", p*100)
+}
+
+// maxHistogramBuckets bounds the histogram with its empty buckets filled
+// in, so that a narrow bucket width over a wide spread of ages cannot make
+// it arbitrarily long.
+const maxHistogramBuckets = 1000
+
+// ageHistogram turns per-bucket counts, keyed by the lowest age of the
+// bucket, into a histogram covering every bucket from minAge to maxAge.
+// When that would take more than maxHistogramBuckets buckets, only the
+// buckets in counts are listed.
+func ageHistogram(counts map[int]int, minAge, maxAge, width int) []models.AgeBucket {
+	histogram := []models.AgeBucket{}
+	if len(counts) == 0 {
+		return histogram
+	}
+	first := minAge / width * width
+	if (maxAge-first)/width < maxHistogramBuckets {
+		for i := range (maxAge-first)/width + 1 {
+			from := first + i*width
+			histogram = append(histogram, models.AgeBucket{From: from, To: from + width, Count: counts[from]})
+		}
+		return histogram
+	}
+	for _, from := range slices.Sorted(maps.Keys(counts)) {
+		histogram = append(histogram, models.AgeBucket{From: from, To: from + width, Count: counts[from]})
+	}
+	return histogram
+}
+
+// Stats runs its queries in one read-only snapshot so the summary and the
+// histogram always describe the same users.
+func (s *PostgresUserStore) Stats(ctx context.Context, minAge, maxAge int, deleted DeletedFilter, bucketWidth int) (models.UserStats, error) {
+	stats := models.UserStats{BucketWidth: bucketWidth, Percentiles: map[string]float64{}}
+
+	whereClauses, params := userFilters(minAge, maxAge, deleted)
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return stats, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var percentiles pq.Float64Array
+	query := fmt.Sprintf(`SELECT COUNT(*), COALESCE(MIN(age), 0), COALESCE(MAX(age), 0), COALESCE(AVG(age), 0),
+		percentile_cont($%d::float8[]) WITHIN GROUP (ORDER BY age)
+		FROM users %s`, len(params)+1, whereClause)
+	err = tx.QueryRowContext(ctx, query, append(params, pq.Array(statsPercentiles))...).
+		Scan(&stats.Count, &stats.MinAge, &stats.MaxAge, &stats.AvgAge, &percentiles)
+	if err != nil {
+		return stats, fmt.Errorf("failed to summarise users: %w", err)
+	}
+	for i, value := range percentiles {
+		stats.Percentiles[percentileKey(statsPercentiles[i])] = value
+	}
+	stats.MedianAge = stats.Percentiles["p50"]
+
+	query = fmt.Sprintf("SELECT age / $%d * $%d AS bucket, COUNT(*) FROM users %s GROUP BY bucket",
+		len(params)+1, len(params)+1, whereClause)
+	rows, err := tx.QueryContext(ctx, query, append(params, bucketWidth)...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query age histogram: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return stats, fmt.Errorf("failed to scan age bucket: %w", err)
+		}
+		counts[bucket] = count
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("row iteration error: %w", err)
+	}
+	stats.Histogram = ageHistogram(counts, stats.MinAge, stats.MaxAge, bucketWidth)
+	return stats, nil
+}
+
+// ageStats computes the same summary as PostgresUserStore.Stats from ages
+// sorted in ascending order. Percentiles are interpolated linearly like
+// percentile_cont.
+func ageStats(ages []int, bucketWidth int) models.UserStats {
+	stats := models.UserStats{Count: len(ages), BucketWidth: bucketWidth, Percentiles: map[string]float64{}}
+	if len(ages) == 0 {
+		stats.Histogram = ageHistogram(nil, 0, 0, bucketWidth)
+		return stats
+	}
+
+	stats.MinAge, stats.MaxAge = ages[0], ages[len(ages)-1]
+	sum := 0
+	counts := map[int]int{}
+	for _, age := range ages {
+		sum += age
+		counts[age/bucketWidth*bucketWidth]++
+	}
+	stats.AvgAge = float64(sum) / float64(len(ages))
+
+	for _, p := range statsPercentiles {
+		pos := p * float64(len(ages)-1)
+		lower := int(math.Floor(pos))
+		value := float64(ages[lower])
+		if lower+1 < len(ages) {
+			value += (pos - float64(lower)) * float64(ages[lower+1]-ages[lower])
+		}
+		stats.Percentiles[percentileKey(p)] = value
+	}
+	stats.MedianAge = stats.Percentiles["p50"]
+	stats.Histogram = ageHistogram(counts, stats.MinAge, stats.MaxAge, bucketWidth)
+	return stats
+}
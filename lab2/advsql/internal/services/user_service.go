@@ -1,35 +1,115 @@
 package services
 
 import (
-	"advsql/internal/database"
 	"advsql/internal/models"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"strings"
+
+	"github.com/lib/pq"
 )
 
-func СreateUsersTable() error {
-	query := `
-   CREATE TABLE IF NOT EXISTS users (
-       id SERIAL PRIMARY KEY,
-       name VARCHAR(255) UNIQUE NOT NULL,
-       age INT NOT NULL
-   );
-   `
-	if _, err := database.DB.Exec(query); err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
-	}
-	log.Println("Users table created or already exists.")
-	return nil
+// UserStore persists users. Handlers depend on this interface rather than on
+// a concrete database so they can be exercised against MemoryUserStore.
+type UserStore interface {
+	// Create inserts all users atomically.
+	Create(ctx context.Context, users []models.User) error
+	// CreateEach inserts users independently of each other and reports the
+	// outcome of every row. Only unexpected failures abort the whole batch.
+	CreateEach(ctx context.Context, users []models.User) ([]CreateResult, error)
+	// BulkLoad streams users from src into the store in one atomic batch
+	// and returns the number of rows inserted. The batch is audited as one
+	// bulk_load entry rather than a create per user.
+	BulkLoad(ctx context.Context, src UserSource) (int64, error)
+	// Upsert creates users whose name is new and updates the age of those
+	// whose name already exists, atomically, reporting which happened.
+	Upsert(ctx context.Context, users []models.User) ([]UpsertResult, error)
+	// Get returns a single user by ID. Deleted users are not found. Its ID
+	// and version are always loaded, whatever fields asks for.
+	Get(ctx context.Context, userID int, fields Fields) (models.User, error)
+	// List returns one page of users and the total number of matches.
+	List(ctx context.Context, minAge, maxAge, page, pageSize int, sort Sort, deleted DeletedFilter, fields Fields) ([]models.User, int, error)
+	// Export passes every user that List would return, across all pages,
+	// to sink one at a time without holding them in memory. It stops at
+	// the first error from sink and returns it.
+	Export(ctx context.Context, minAge, maxAge int, sort Sort, sink UserSink) error
+	// Stats summarises the ages of the users List would return, with a
+	// histogram of buckets bucketWidth years wide.
+	Stats(ctx context.Context, minAge, maxAge int, deleted DeletedFilter, bucketWidth int) (models.UserStats, error)
+	// ListByCursor returns one page of users using keyset pagination along
+	// with the cursors of the following and preceding pages. The ID and the
+	// sort keys are always loaded, whatever fields asks for.
+	ListByCursor(ctx context.Context, minAge, maxAge, pageSize int, sort Sort, cursor string, deleted DeletedFilter, fields Fields) ([]models.User, string, string, error)
+	// Update overwrites the name and age of an existing user whose version
+	// is user.Version and returns it with its new version. It fails with
+	// ErrVersionMismatch if the user was changed since; a zero Version
+	// skips the check.
+	Update(ctx context.Context, user models.User) (models.User, error)
+	// Patch locks a user, passes it to apply and writes back only the
+	// fields apply changed, all in one transaction. Like Update it checks
+	// version unless it is zero. Errors from apply are returned unchanged.
+	Patch(ctx context.Context, userID, version int, apply func(models.User) (models.User, error)) (models.User, error)
+	// Delete soft-deletes a user by ID: it disappears from Get and List
+	// but keeps its name reserved until it is restored.
+	Delete(ctx context.Context, userID int) error
+	// Restore undeletes a user. It fails with ErrNotDeleted if the user
+	// was never deleted.
+	Restore(ctx context.Context, userID int) (models.User, error)
+	// ListAudit returns one page of the audit trail, newest first, and the
+	// total number of matching entries. Every write above records its
+	// changes there, tagged with the audit.Info of its context.
+	ListAudit(ctx context.Context, filter AuditFilter, page, pageSize int) ([]models.AuditEntry, int, error)
+}
+
+// DeletedFilter selects users by whether they have been soft-deleted.
+type DeletedFilter int
+
+const (
+	ExcludeDeleted DeletedFilter = iota
+	IncludeDeleted
+	OnlyDeleted
+)
+
+// CreateResult is the outcome of inserting one row with CreateEach. Err is
+// nil on success and otherwise matches ErrConflict or ErrInvalid.
+type CreateResult struct {
+	ID  int
+	Err error
+}
+
+// UpsertResult is the stored state of one user written by Upsert.
+type UpsertResult struct {
+	User    models.User
+	Created bool
+}
+
+// UserSource yields the users of a bulk load one at a time. It returns
+// io.EOF once the input is exhausted.
+type UserSource func() (models.User, error)
+
+// UserSink consumes the users of an export one at a time.
+type UserSink func(models.User) error
+
+// PostgresUserStore is a UserStore backed by the users table.
+type PostgresUserStore struct {
+	db *sql.DB
 }
 
-func CreateUser(users []models.User) error {
-	tx, err := database.DB.Begin()
+// NewPostgresUserStore returns a UserStore that uses db.
+func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
+	return &PostgresUserStore{db: db}
+}
+
+func (s *PostgresUserStore) Create(ctx context.Context, users []models.User) error {
+	tx, err := s.beginTx(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO users (name, age) VALUES ($1, $2)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO users (name, age) VALUES ($1, $2)")
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to prepare statement: %w", err)
@@ -37,25 +117,203 @@ func CreateUser(users []models.User) error {
 	defer stmt.Close()
 
 	for _, user := range users {
-		if _, err := stmt.Exec(user.Name, user.Age); err != nil {
+		if _, err := stmt.ExecContext(ctx, user.Name, user.Age); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to execute statement: %w", err)
+			return fmt.Errorf("failed to execute statement: %w", translateError(err))
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", translateError(err))
 	}
 	return nil
 }
 
-func GetUsers(minAge, maxAge, page, pageSize int, sort string) ([]models.User, int, error) {
-	offset := (page - 1) * pageSize
+// CreateEach wraps every insert in a savepoint, so a row that violates a
+// constraint is rolled back on its own while the rest of the batch commits.
+func (s *PostgresUserStore) CreateEach(ctx context.Context, users []models.User) ([]CreateResult, error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO users (name, age) VALUES ($1, $2) RETURNING id")
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	results := make([]CreateResult, len(users))
+	for i, user := range users {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_row"); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to create savepoint: %w", err)
+		}
+
+		err := stmt.QueryRowContext(ctx, user.Name, user.Age).Scan(&results[i].ID)
+		if err == nil {
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT bulk_row"); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("failed to release savepoint: %w", err)
+			}
+			continue
+		}
+
+		err = translateError(err)
+		if !errors.Is(err, ErrConflict) && !errors.Is(err, ErrInvalid) {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to execute statement: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_row"); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to roll back savepoint: %w", err)
+		}
+		results[i].Err = err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return results, nil
+}
+
+// BulkLoad sends the rows with COPY FROM STDIN, which avoids a round trip
+// per row. Rows are pulled from src as they are sent, so the batch is never
+// held in memory. The load is audited as a single bulk_load entry.
+func (s *PostgresUserStore) BulkLoad(ctx context.Context, src UserSource) (int64, error) {
+	tx, err := s.beginBulkTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The users loaded here get IDs past the highest one so far, which
+	// narrows the search for them when they are audited.
+	var lastID int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM users").Scan(&lastID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to query last user ID: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("users", "name", "age"))
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to start copy: %w", err)
+	}
+
+	var count int64
+	for {
+		user, err := src()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		if _, err := stmt.ExecContext(ctx, user.Name, user.Age); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to copy row %d: %w", count, translateError(err))
+		}
+		count++
+	}
+
+	// The final Exec without arguments flushes the buffered rows; constraint
+	// violations surface here.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to finish copy: %w", translateError(err))
+	}
+	if err := stmt.Close(); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to close copy: %w", translateError(err))
+	}
+	if err := recordBulkLoad(ctx, tx, lastID, count); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return count, nil
+}
+
+// Upsert relies on the unique constraint on name. xmax is zero only for a
+// freshly inserted row version, which tells inserts and updates apart. A
+// deleted user with the same name is restored and updated.
+func (s *PostgresUserStore) Upsert(ctx context.Context, users []models.User) ([]UpsertResult, error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+   INSERT INTO users (name, age) VALUES ($1, $2)
+   ON CONFLICT (name) DO UPDATE SET age = EXCLUDED.age, version = users.version + 1, deleted_at = NULL
+   RETURNING id, name, age, version, (xmax = 0) AS inserted
+   `)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	results := make([]UpsertResult, len(users))
+	for i, user := range users {
+		res := &results[i]
+		err := stmt.QueryRowContext(ctx, user.Name, user.Age).Scan(&res.User.ID, &res.User.Name, &res.User.Age, &res.User.Version, &res.Created)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to upsert user: %w", translateError(err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return results, nil
+}
+
+func (s *PostgresUserStore) Get(ctx context.Context, userID int, fields Fields) (models.User, error) {
+	// Only live users are found, so deleted_at is always NULL.
+	var columns []string
+	for _, column := range fields.columns("id", "version") {
+		if column != "deleted_at" {
+			columns = append(columns, column)
+		}
+	}
 
+	var user models.User
+	query := fmt.Sprintf("SELECT %s FROM users WHERE id = $1 AND deleted_at IS NULL", strings.Join(columns, ", "))
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(scanTargets(&user, columns)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func userFilters(minAge, maxAge int, deleted DeletedFilter) ([]string, []interface{}) {
 	var whereClauses []string
 	var params []interface{}
 
+	switch deleted {
+	case ExcludeDeleted:
+		whereClauses = append(whereClauses, "deleted_at IS NULL")
+	case OnlyDeleted:
+		whereClauses = append(whereClauses, "deleted_at IS NOT NULL")
+	}
+
 	if minAge > 0 {
 		whereClauses = append(whereClauses, fmt.Sprintf("age >= $%d", len(params)+1))
 		params = append(params, minAge)
@@ -64,6 +322,13 @@ func GetUsers(minAge, maxAge, page, pageSize int, sort string) ([]models.User, i
 		whereClauses = append(whereClauses, fmt.Sprintf("age <= $%d", len(params)+1))
 		params = append(params, maxAge)
 	}
+	return whereClauses, params
+}
+
+func (s *PostgresUserStore) List(ctx context.Context, minAge, maxAge, page, pageSize int, sort Sort, deleted DeletedFilter, fields Fields) ([]models.User, int, error) {
+	offset := (page - 1) * pageSize
+
+	whereClauses, params := userFilters(minAge, maxAge, deleted)
 
 	whereClause := ""
 	if len(whereClauses) > 0 {
@@ -72,69 +337,229 @@ func GetUsers(minAge, maxAge, page, pageSize int, sort string) ([]models.User, i
 
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM users %s", whereClause)
 	var totalCount int
-	err := database.DB.QueryRow(countQuery, params...).Scan(&totalCount)
+	err := s.db.QueryRowContext(ctx, countQuery, params...).Scan(&totalCount)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count users: %w", err)
 	}
 
-	query := fmt.Sprintf("SELECT id, name, age FROM users %s", whereClause)
-
-	switch sort {
-	case "name_asc":
-		query += " ORDER BY name ASC"
-	case "name_desc":
-		query += " ORDER BY name DESC"
-	default:
-		query += " ORDER BY id"
-	}
+	columns := fields.columns()
+	query := fmt.Sprintf("SELECT %s FROM users %s%s", strings.Join(columns, ", "), whereClause, normalizeSort(sort).orderBy(false))
 
 	limitIndex := len(params) + 1
 	offsetIndex := len(params) + 2
 	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", limitIndex, offsetIndex)
 	queryParams := append(params, pageSize, offset)
 
-	rows, err := database.DB.Query(query, queryParams...)
+	rows, err := s.db.QueryContext(ctx, query, queryParams...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query users: %w", err)
 	}
 	defer rows.Close()
 
+	users, err := scanUsers(rows, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, totalCount, nil
+}
+
+// Export reads the whole result set through a single cursor. lib/pq
+// decodes rows as they are read from the connection, so memory use does
+// not grow with the number of users.
+func (s *PostgresUserStore) Export(ctx context.Context, minAge, maxAge int, sort Sort, sink UserSink) error {
+	whereClauses, params := userFilters(minAge, maxAge, ExcludeDeleted)
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := fmt.Sprintf("SELECT id, name, age, version FROM users %s%s", whereClause, normalizeSort(sort).orderBy(false))
+	rows, err := s.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Age, &user.Version); err != nil {
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		if err := sink(user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+	return nil
+}
+
+// ListByCursor pages using keyset pagination on the active sort key. An
+// empty cursor starts from the beginning of the listing. The returned next
+// and prev cursors are empty when there is no such page.
+func (s *PostgresUserStore) ListByCursor(ctx context.Context, minAge, maxAge, pageSize int, sort Sort, cursor string, deleted DeletedFilter, fields Fields) ([]models.User, string, string, error) {
+	sort = normalizeSort(sort)
+
+	after, err := resolveCursor(sort, cursor)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	whereClauses, params := userFilters(minAge, maxAge, deleted)
+
+	// Walking backwards flips both the seek comparison and the ordering;
+	// the page is reversed again after scanning.
+	backward := after != nil && after.Backward
+	if after != nil {
+		var seek string
+		seek, params = seekClause(sort, after, backward, params)
+		whereClauses = append(whereClauses, seek)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	// The cursors are built from the sort keys of the first and last rows.
+	keys := make([]string, len(sort))
+	for i, key := range sort {
+		keys[i] = key.Field
+	}
+	columns := fields.columns(keys...)
+	query := fmt.Sprintf("SELECT %s FROM users %s%s", strings.Join(columns, ", "), whereClause, sort.orderBy(backward))
+	// One extra row tells us whether another page exists in this direction.
+	query += fmt.Sprintf(" LIMIT $%d", len(params)+1)
+	params = append(params, pageSize+1)
+
+	rows, err := s.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows, columns)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	users, next, prev := keysetPage(users, pageSize, sort, after)
+	return users, next, prev, nil
+}
+
+func scanUsers(rows *sql.Rows, columns []string) ([]models.User, error) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Age); err != nil {
-			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		if err := rows.Scan(scanTargets(&user, columns)...); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
 		users = append(users, user)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+		return nil, fmt.Errorf("row iteration error: %w", err)
 	}
+	return users, nil
+}
 
-	return users, totalCount, nil
+func (s *PostgresUserStore) Update(ctx context.Context, user models.User) (models.User, error) {
+	query := "UPDATE users SET name = $1, age = $2, version = version + 1 WHERE id = $3 AND deleted_at IS NULL"
+	args := []interface{}{user.Name, user.Age, user.ID}
+	if user.Version != 0 {
+		query += " AND version = $4"
+		args = append(args, user.Version)
+	}
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query+" RETURNING version", args...).Scan(&user.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either the user is gone or its version moved on.
+		tx.Rollback()
+		if _, err := s.Get(ctx, user.ID, nil); err != nil {
+			return models.User{}, err
+		}
+		return models.User{}, ErrVersionMismatch
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to update user: %w", translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return user, nil
 }
 
-func UpdateUser(user models.User) error {
-	result, err := database.DB.Exec("UPDATE users SET name = $1, age = $2 WHERE id = $3", user.Name, user.Age, user.ID)
+func (s *PostgresUserStore) Patch(ctx context.Context, userID, version int, apply func(models.User) (models.User, error)) (models.User, error) {
+	tx, err := s.beginTx(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return models.User{}, err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var current models.User
+	err = tx.QueryRowContext(ctx, "SELECT id, name, age, version FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).
+		Scan(&current.ID, &current.Name, &current.Age, &current.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, ErrNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("failed to retrieve rows affected: %w", err)
+		return models.User{}, fmt.Errorf("failed to get user: %w", err)
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+	if version != 0 && version != current.Version {
+		return models.User{}, ErrVersionMismatch
 	}
-	return nil
+
+	user, err := apply(current)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.ID = current.ID
+	user.Version = current.Version
+
+	var sets []string
+	var args []interface{}
+	if user.Name != current.Name {
+		args = append(args, user.Name)
+		sets = append(sets, fmt.Sprintf("name = $%d", len(args)))
+	}
+	if user.Age != current.Age {
+		args = append(args, user.Age)
+		sets = append(sets, fmt.Sprintf("age = $%d", len(args)))
+	}
+	if len(sets) > 0 {
+		args = append(args, user.ID)
+		query := fmt.Sprintf("UPDATE users SET %s, version = version + 1 WHERE id = $%d RETURNING version", strings.Join(sets, ", "), len(args))
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version); err != nil {
+			return models.User{}, fmt.Errorf("failed to update user: %w", translateError(err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return user, nil
 }
 
-func DeleteUser(userID int) error {
-	result, err := database.DB.Exec("DELETE FROM users WHERE id = $1", userID)
+func (s *PostgresUserStore) Delete(ctx context.Context, userID int) error {
+	tx, err := s.beginTx(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", translateError(err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -142,7 +567,39 @@ func DeleteUser(userID int) error {
 		return fmt.Errorf("failed to retrieve rows affected: %w", err)
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return ErrNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", translateError(err))
 	}
 	return nil
 }
+
+func (s *PostgresUserStore) Restore(ctx context.Context, userID int) (models.User, error) {
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	var user models.User
+	err = tx.QueryRowContext(ctx, "UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING id, name, age, version", userID).
+		Scan(&user.ID, &user.Name, &user.Age, &user.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either there is no such user or it is not deleted.
+		tx.Rollback()
+		if _, err := s.Get(ctx, userID, nil); err != nil {
+			return models.User{}, err
+		}
+		return models.User{}, ErrNotDeleted
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to restore user: %w", translateError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, fmt.Errorf("failed to commit transaction: %w", translateError(err))
+	}
+	return user, nil
+}
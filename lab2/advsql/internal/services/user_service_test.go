@@ -0,0 +1,792 @@
+package services_test
+
+import (
+	"advsql/internal/audit"
+	"advsql/internal/models"
+	"advsql/internal/services"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func setupMockStore(t *testing.T) (*services.PostgresUserStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Не удалось подключиться к sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return services.NewPostgresUserStore(db), mock
+}
+
+// expectBegin expects a transaction to start and to be tagged for the audit
+// trail.
+func expectBegin(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT set_config\('audit.actor', \$1, true\), set_config\('audit.request_id', \$2, true\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestPostgresList(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	minAge := 18
+	maxAge := 30
+	page := 1
+	pageSize := 10
+
+	params := []driver.Value{minAge, maxAge}
+
+	countQuery := `SELECT COUNT\(\*\) FROM users WHERE deleted_at IS NULL AND age >= \$1 AND age <= \$2`
+	mock.ExpectQuery(countQuery).
+		WithArgs(params...).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	userRows := sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).
+		AddRow(1, "John Doe", 25, 1, nil).
+		AddRow(2, "Jane Doe", 30, 1, nil)
+
+	expectedSelectQuery := `SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NULL AND age >= \$1 AND age <= \$2 ORDER BY name ASC, id ASC LIMIT \$3 OFFSET \$4`
+	queryParams := append(params, driver.Value(pageSize), driver.Value((page-1)*pageSize))
+
+	mock.ExpectQuery(expectedSelectQuery).
+		WithArgs(queryParams...).
+		WillReturnRows(userRows)
+
+	users, total, err := store.List(context.Background(), minAge, maxAge, page, pageSize, services.Sort{{Field: "name"}}, services.ExcludeDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение списка пользователей завершилось с ошибкой: %v", err)
+	}
+	if total != 2 || len(users) != 2 {
+		t.Errorf("Ожидалось 2 пользователя, получили %v из %v", len(users), total)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresListByCursor(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	firstPage := sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).
+		AddRow(2, "Alice", 25, 1, nil).
+		AddRow(1, "Bob", 30, 1, nil).
+		AddRow(3, "Carol", 28, 1, nil)
+	mock.ExpectQuery(`SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NULL AND age >= \$1 ORDER BY name ASC, id ASC LIMIT \$2`).
+		WithArgs(18, 3).
+		WillReturnRows(firstPage)
+
+	users, next, prev, err := store.ListByCursor(context.Background(), 18, 0, 2, services.Sort{{Field: "name"}}, "", services.ExcludeDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение страницы завершилось с ошибкой: %v", err)
+	}
+	if len(users) != 2 || next == "" || prev != "" {
+		t.Fatalf("Неверная первая страница: %v %q %q", users, next, prev)
+	}
+
+	secondPage := sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).
+		AddRow(3, "Carol", 28, 1, nil)
+	mock.ExpectQuery(`SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NULL AND age >= \$1 AND \(name, id\) > \(\$2, \$3\) ORDER BY name ASC, id ASC LIMIT \$4`).
+		WithArgs(18, "Bob", 1, 3).
+		WillReturnRows(secondPage)
+
+	users, next, prev, err = store.ListByCursor(context.Background(), 18, 0, 2, services.Sort{{Field: "name"}}, next, services.ExcludeDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение страницы завершилось с ошибкой: %v", err)
+	}
+	if len(users) != 1 || next != "" || prev == "" {
+		t.Errorf("Неверная вторая страница: %v %q %q", users, next, prev)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresCreate(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectPrepare("INSERT INTO users").
+		ExpectExec().
+		WithArgs("John Doe", 25).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := store.Create(context.Background(), []models.User{{Name: "John Doe", Age: 25}}); err != nil {
+		t.Errorf("Создание пользователя завершилось с ошибкой: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresCreateDuplicateName(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectPrepare("INSERT INTO users").
+		ExpectExec().
+		WithArgs("John Doe", 25).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_name_key", Message: "duplicate key value violates unique constraint"})
+	mock.ExpectRollback()
+
+	err := store.Create(context.Background(), []models.User{{Name: "John Doe", Age: 25}})
+	if !errors.Is(err, services.ErrConflict) {
+		t.Fatalf("Ожидалась ошибка конфликта, получили %v", err)
+	}
+
+	var constraintErr *services.ConstraintError
+	if !errors.As(err, &constraintErr) || constraintErr.Constraint != "users_name_key" {
+		t.Errorf("Ожидалась ошибка ограничения users_name_key, получили %v", err)
+	}
+}
+
+func TestPostgresCreateEach(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	prepared := mock.ExpectPrepare("INSERT INTO users")
+	mock.ExpectExec("SAVEPOINT bulk_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepared.ExpectQuery().
+		WithArgs("John Doe", 25).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_name_key"})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT bulk_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT bulk_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	prepared.ExpectQuery().
+		WithArgs("Jane Doe", 30).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("RELEASE SAVEPOINT bulk_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	results, err := store.CreateEach(context.Background(), []models.User{
+		{Name: "John Doe", Age: 25},
+		{Name: "Jane Doe", Age: 30},
+	})
+	if err != nil {
+		t.Fatalf("Создание пользователей завершилось с ошибкой: %v", err)
+	}
+	if !errors.Is(results[0].Err, services.ErrConflict) {
+		t.Errorf("Ожидалась ошибка конфликта для первой строки, получили %v", results[0].Err)
+	}
+	if results[1].Err != nil || results[1].ID != 7 {
+		t.Errorf("Неверный результат для второй строки: %+v", results[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresBulkLoad(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	users := []models.User{{Name: "John Doe", Age: 25}, {Name: "Jane Doe", Age: 30}}
+
+	expectBegin(mock)
+	mock.ExpectExec(`SELECT set_config\('audit.bulk', 'on', true\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(id\), 0\) FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(7))
+	copyIn := mock.ExpectPrepare(`COPY "users" \("name", "age"\) FROM STDIN`)
+	for _, u := range users {
+		copyIn.ExpectExec().WithArgs(u.Name, u.Age).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	copyIn.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO user_audit \(user_id, action, after, actor, request_id\)\s+SELECT id, \$1, to_jsonb\(users\), \$2, \$3 FROM users\s+WHERE id > \$4 AND xmin = pg_current_xact_id\(\)::xid`).
+		WithArgs("create", "", "", 7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO user_audit \(user_id, action, after, actor, request_id\) VALUES \(0, \$1, jsonb_build_object\('inserted', \$2::bigint\), \$3, \$4\)`).
+		WithArgs("bulk_load", int64(2), "", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	i := 0
+	src := func() (models.User, error) {
+		if i == len(users) {
+			return models.User{}, io.EOF
+		}
+		i++
+		return users[i-1], nil
+	}
+
+	count, err := store.BulkLoad(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Загрузка завершилась с ошибкой: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Ожидалось 2 строки, получили %v", count)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestMemoryBulkLoadReadsBeforeLocking(t *testing.T) {
+	store := services.NewMemoryUserStore()
+	users := []models.User{{Name: "John Doe", Age: 25}, {Name: "Jane Doe", Age: 30}}
+
+	i := 0
+	src := func() (models.User, error) {
+		// Other requests must get through while the upload is still being read.
+		listed := make(chan error, 1)
+		go func() {
+			_, _, err := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil)
+			listed <- err
+		}()
+		select {
+		case err := <-listed:
+			if err != nil {
+				t.Errorf("Ошибка чтения во время загрузки: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Чтение заблокировано, пока загрузка читает источник")
+		}
+
+		if i == len(users) {
+			return models.User{}, io.EOF
+		}
+		i++
+		return users[i-1], nil
+	}
+
+	count, err := store.BulkLoad(context.Background(), src)
+	if err != nil || count != 2 {
+		t.Fatalf("Загрузка: получили %v, %v; ожидали 2 строки", count, err)
+	}
+	if _, err := store.BulkLoad(context.Background(), func() (models.User, error) {
+		if i == 0 {
+			return models.User{}, io.EOF
+		}
+		i = 0
+		return users[0], nil
+	}); !errors.Is(err, services.ErrConflict) {
+		t.Errorf("Ожидалась ошибка ErrConflict, получили %v", err)
+	}
+}
+
+func TestPostgresUpsert(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	upsert := mock.ExpectPrepare(`INSERT INTO users \(name, age\) VALUES \(\$1, \$2\)\s+ON CONFLICT \(name\) DO UPDATE SET age = EXCLUDED.age`)
+	upsert.ExpectQuery().
+		WithArgs("John Doe", 26).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "inserted"}).AddRow(1, "John Doe", 26, 2, false))
+	upsert.ExpectQuery().
+		WithArgs("Jane Doe", 30).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "inserted"}).AddRow(2, "Jane Doe", 30, 1, true))
+	mock.ExpectCommit()
+
+	results, err := store.Upsert(context.Background(), []models.User{
+		{Name: "John Doe", Age: 26},
+		{Name: "Jane Doe", Age: 30},
+	})
+	if err != nil {
+		t.Fatalf("Upsert завершился с ошибкой: %v", err)
+	}
+	if results[0].Created || results[0].User.ID != 1 || !results[1].Created || results[1].User.ID != 2 {
+		t.Errorf("Неверные результаты: %+v", results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresUpdateCheckViolation(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery("UPDATE users SET").
+		WithArgs("Jane Doe", -1, 1).
+		WillReturnError(&pq.Error{Code: "23514", Constraint: "users_age_check"})
+	mock.ExpectRollback()
+
+	_, err := store.Update(context.Background(), models.User{ID: 1, Name: "Jane Doe", Age: -1})
+	if !errors.Is(err, services.ErrInvalid) {
+		t.Errorf("Ожидалась ошибка валидации, получили %v", err)
+	}
+}
+
+func TestPostgresUpdateNotFound(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery("UPDATE users SET").
+		WithArgs("Jane Doe", 30, 42).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}))
+
+	_, err := store.Update(context.Background(), models.User{ID: 42, Name: "Jane Doe", Age: 30})
+	if !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Ожидалась ошибка ErrNotFound, получили %v", err)
+	}
+}
+
+func TestPostgresUpdateVersionMismatch(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`UPDATE users SET name = \$1, age = \$2, version = version \+ 1 WHERE id = \$3 AND deleted_at IS NULL AND version = \$4 RETURNING version`).
+		WithArgs("Jane Doe", 30, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 3))
+
+	_, err := store.Update(context.Background(), models.User{ID: 1, Name: "Jane Doe", Age: 30, Version: 2})
+	if !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("Ожидалась ошибка ErrVersionMismatch, получили %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresGetNotFound(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1`).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}))
+
+	if _, err := store.Get(context.Background(), 42, nil); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Ожидалась ошибка ErrNotFound, получили %v", err)
+	}
+}
+
+func TestPostgresUpdate(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery("UPDATE users SET").
+		WithArgs("Jane Doe", 30, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectCommit()
+
+	user, err := store.Update(context.Background(), models.User{ID: 1, Name: "Jane Doe", Age: 30, Version: 1})
+	if err != nil {
+		t.Errorf("Обновление пользователя завершилось с ошибкой: %v", err)
+	}
+	if user.Version != 2 {
+		t.Errorf("Неверная версия: получили %v, ожидали 2", user.Version)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresPatch(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectQuery(`UPDATE users SET age = \$1, version = version \+ 1 WHERE id = \$2 RETURNING version`).
+		WithArgs(31, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectCommit()
+
+	user, err := store.Patch(context.Background(), 1, 1, func(user models.User) (models.User, error) {
+		user.Age = 31
+		return user, nil
+	})
+	if err != nil {
+		t.Fatalf("Частичное обновление завершилось с ошибкой: %v", err)
+	}
+	if user.Name != "John Doe" || user.Age != 31 || user.Version != 2 {
+		t.Errorf("Неверные данные пользователя: %v", user)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresPatchVersionMismatch(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 3))
+	mock.ExpectRollback()
+
+	_, err := store.Patch(context.Background(), 1, 2, func(user models.User) (models.User, error) {
+		t.Error("Патч не должен применяться к устаревшей версии")
+		return user, nil
+	})
+	if !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("Ожидалась ошибка ErrVersionMismatch, получили %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresPatchApplyError(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectRollback()
+
+	applyErr := errors.New("rejected")
+	_, err := store.Patch(context.Background(), 1, 1, func(user models.User) (models.User, error) {
+		return user, applyErr
+	})
+	if !errors.Is(err, applyErr) {
+		t.Errorf("Ожидалась ошибка %v, получили %v", applyErr, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresDelete(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectExec(`UPDATE users SET deleted_at = now\(\) WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := store.Delete(context.Background(), 1); err != nil {
+		t.Errorf("Удаление пользователя завершилось с ошибкой: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresListTrash(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	deletedAt := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE deleted_at IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NOT NULL ORDER BY id ASC LIMIT \$1 OFFSET \$2`).
+		WithArgs(10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).AddRow(1, "John Doe", 25, 1, deletedAt))
+
+	users, total, err := store.List(context.Background(), 0, 0, 1, 10, nil, services.OnlyDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение корзины завершилось с ошибкой: %v", err)
+	}
+	if total != 1 || len(users) != 1 || users[0].DeletedAt == nil || !users[0].DeletedAt.Equal(deletedAt) {
+		t.Errorf("Неверное содержимое корзины: %+v", users)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresRestore(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`UPDATE users SET deleted_at = NULL WHERE id = \$1 AND deleted_at IS NOT NULL RETURNING id, name, age, version`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectCommit()
+
+	user, err := store.Restore(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Восстановление пользователя завершилось с ошибкой: %v", err)
+	}
+	if user.ID != 1 || user.Name != "John Doe" {
+		t.Errorf("Неверные данные пользователя: %+v", user)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresRestoreNotDeleted(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	expectBegin(mock)
+	mock.ExpectQuery(`UPDATE users SET deleted_at = NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+
+	if _, err := store.Restore(context.Background(), 1); !errors.Is(err, services.ErrNotDeleted) {
+		t.Errorf("Ожидалась ошибка ErrNotDeleted, получили %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresDeleteTagsAudit(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT set_config`).
+		WithArgs("alice", "req-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE users SET deleted_at`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	ctx := audit.NewContext(context.Background(), audit.Info{Actor: "alice", RequestID: "req-1"})
+	if err := store.Delete(ctx, 1); err != nil {
+		t.Errorf("Удаление пользователя завершилось с ошибкой: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresListAudit(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_audit WHERE user_id = \$1 AND action = \$2 AND created_at >= \$3`).
+		WithArgs(1, "update", since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT id, user_id, action, before, after, actor, request_id, created_at FROM user_audit WHERE user_id = \$1 AND action = \$2 AND created_at >= \$3 ORDER BY id DESC LIMIT \$4 OFFSET \$5`).
+		WithArgs(1, "update", since, 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "action", "before", "after", "actor", "request_id", "created_at"}).
+			AddRow(7, 1, "update", []byte(`{"id":1,"age":25}`), []byte(`{"id":1,"age":26}`), "alice", "req-1", since))
+
+	filter := services.AuditFilter{UserID: 1, Action: "update", Since: since}
+	entries, total, err := store.ListAudit(context.Background(), filter, 1, 10)
+	if err != nil {
+		t.Fatalf("Получение журнала завершилось с ошибкой: %v", err)
+	}
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("Неверное количество записей: %v, %v", total, len(entries))
+	}
+	if entries[0].Actor != "alice" || string(entries[0].After) != `{"id":1,"age":26}` {
+		t.Errorf("Неверная запись журнала: %+v", entries[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresExport(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users WHERE deleted_at IS NULL AND age >= \$1 ORDER BY name DESC, id ASC$`).
+		WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).
+			AddRow(1, "John Doe", 25, 1).
+			AddRow(2, "Jane Doe", 30, 1))
+
+	var names []string
+	err := store.Export(context.Background(), 18, 0, services.Sort{{Field: "name", Desc: true}}, func(user models.User) error {
+		names = append(names, user.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Экспорт завершился с ошибкой: %v", err)
+	}
+	if len(names) != 2 || names[0] != "John Doe" {
+		t.Errorf("Неверные пользователи: %v", names)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresExportStopsOnSinkError(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	mock.ExpectQuery(`SELECT id, name, age, version FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).
+			AddRow(1, "John Doe", 25, 1).
+			AddRow(2, "Jane Doe", 30, 1))
+
+	stop := errors.New("client went away")
+	calls := 0
+	err := store.Export(context.Background(), 0, 0, nil, func(models.User) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Экспорт не остановился: ошибка %v, вызовов %v", err, calls)
+	}
+}
+
+func TestPostgresStats(t *testing.T) {
+	store, mock := setupMockStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE\(MIN\(age\), 0\), COALESCE\(MAX\(age\), 0\), COALESCE\(AVG\(age\), 0\),\s+percentile_cont\(\$2::float8\[\]\) WITHIN GROUP \(ORDER BY age\)\s+FROM users WHERE deleted_at IS NULL AND age >= \$1`).
+		WithArgs(18, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min", "max", "avg", "percentiles"}).
+			AddRow(3, 18, 42, "30.0000000000000000", "{24,30,36,39.6,40.8,41.76}"))
+	mock.ExpectQuery(`SELECT age / \$2 \* \$2 AS bucket, COUNT\(\*\) FROM users WHERE deleted_at IS NULL AND age >= \$1 GROUP BY bucket`).
+		WithArgs(18, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow(10, 1).
+			AddRow(40, 2))
+	mock.ExpectRollback()
+
+	stats, err := store.Stats(context.Background(), 18, 0, services.ExcludeDeleted, 10)
+	if err != nil {
+		t.Fatalf("Stats завершился с ошибкой: %v", err)
+	}
+	if stats.Count != 3 || stats.MinAge != 18 || stats.MaxAge != 42 || stats.AvgAge != 30 || stats.MedianAge != 30 {
+		t.Errorf("Неверная сводка: %+v", stats)
+	}
+	if stats.Percentiles["p90"] != 39.6 || len(stats.Percentiles) != 6 {
+		t.Errorf("Неверные перцентили: %v", stats.Percentiles)
+	}
+	want := []models.AgeBucket{{From: 10, To: 20, Count: 1}, {From: 20, To: 30}, {From: 30, To: 40}, {From: 40, To: 50, Count: 2}}
+	if !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Неверная гистограмма: %+v", stats.Histogram)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "id:asc"},
+		{"name_asc", "name:asc,id:asc"},
+		{"name_desc", "name:desc,id:asc"},
+		{"age:desc,name", "age:desc,name:asc,id:asc"},
+		{" age : DESC , id:desc ", "age:desc,id:desc"},
+	}
+	for _, tt := range tests {
+		sort, err := services.ParseSort(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSort(%q) завершился с ошибкой: %v", tt.expr, err)
+			continue
+		}
+		if got := sort.String(); got != tt.want {
+			t.Errorf("ParseSort(%q) = %q, ожидали %q", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"email", "age:up", "age,age:desc", "name,"} {
+		if _, err := services.ParseSort(expr); !errors.Is(err, services.ErrInvalidSort) {
+			t.Errorf("ParseSort(%q): ожидалась ошибка ErrInvalidSort, получили %v", expr, err)
+		}
+	}
+}
+
+func TestPostgresListByCursorMixedSort(t *testing.T) {
+	store, mock := setupMockStore(t)
+	sort := services.Sort{{Field: "age", Desc: true}, {Field: "name"}}
+
+	mock.ExpectQuery(`SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NULL ORDER BY age DESC, name ASC, id ASC LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).
+			AddRow(1, "Bob", 30, 1, nil).
+			AddRow(3, "Carol", 28, 1, nil))
+
+	_, next, _, err := store.ListByCursor(context.Background(), 0, 0, 1, sort, "", services.ExcludeDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение страницы завершилось с ошибкой: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT id, name, age, version, deleted_at FROM users WHERE deleted_at IS NULL AND \(age < \$1 OR \(age = \$1 AND \(name > \$2 OR \(name = \$2 AND \(id > \$3\)\)\)\)\) ORDER BY age DESC, name ASC, id ASC LIMIT \$4`).
+		WithArgs(30, "Bob", 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).
+			AddRow(3, "Carol", 28, 1, nil))
+
+	users, _, prev, err := store.ListByCursor(context.Background(), 0, 0, 1, sort, next, services.ExcludeDeleted, nil)
+	if err != nil {
+		t.Fatalf("Получение страницы завершилось с ошибкой: %v", err)
+	}
+	if len(users) != 1 || users[0].Name != "Carol" || prev == "" {
+		t.Errorf("Неверная вторая страница: %v %q", users, prev)
+	}
+
+	if _, _, _, err := store.ListByCursor(context.Background(), 0, 0, 1, services.Sort{{Field: "name"}}, next, services.ExcludeDeleted, nil); !errors.Is(err, services.ErrInvalidCursor) {
+		t.Errorf("Курсор другой сортировки должен быть отклонён, получили %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPostgresSelectsFields(t *testing.T) {
+	store, mock := setupMockStore(t)
+	fields := services.Fields{"name"}
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT name FROM users WHERE deleted_at IS NULL ORDER BY id ASC LIMIT \$1 OFFSET \$2`).
+		WithArgs(10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("John Doe"))
+
+	users, _, err := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, fields)
+	if err != nil {
+		t.Fatalf("Получение списка завершилось с ошибкой: %v", err)
+	}
+	if len(users) != 1 || users[0] != (models.User{Name: "John Doe"}) {
+		t.Errorf("Неверные пользователи: %+v", users)
+	}
+
+	mock.ExpectQuery(`SELECT id, name, age FROM users WHERE deleted_at IS NULL ORDER BY age ASC, id ASC LIMIT \$1`).
+		WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow(1, "John Doe", 25))
+
+	if _, _, _, err := store.ListByCursor(context.Background(), 0, 0, 10, services.Sort{{Field: "age"}}, "", services.ExcludeDeleted, fields); err != nil {
+		t.Fatalf("Получение страницы завершилось с ошибкой: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT id, name, version FROM users WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(1, "John Doe", 3))
+
+	user, err := store.Get(context.Background(), 1, fields)
+	if err != nil {
+		t.Fatalf("Получение пользователя завершилось с ошибкой: %v", err)
+	}
+	if user != (models.User{ID: 1, Name: "John Doe", Version: 3}) {
+		t.Errorf("Неверный пользователь: %+v", user)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+
+	if _, err := services.ParseFields("id,email"); !errors.Is(err, services.ErrInvalidFields) {
+		t.Errorf("Ожидалась ошибка ErrInvalidFields, получили %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys for the rows a statement returned or changed.
+const (
+	ReturnedRowsKey = attribute.Key("db.response.returned_rows")
+	RowsAffectedKey = attribute.Key("db.rows_affected")
+)
+
+// WrapConnector returns a connector whose connections record a client span
+// for every statement they run, with its text (placeholders, never the
+// arguments) and the number of rows it returned or changed, and for every
+// commit and rollback. Use it with sql.OpenDB.
+//
+// The rows of a COPY FROM STDIN are sent as executions of its prepared
+// statement; they are not traced one by one.
+func WrapConnector(c driver.Connector) driver.Connector {
+	return connector{c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tracedConn{conn}, nil
+}
+
+// startSpan starts the span of a statement, named after its operation such
+// as SELECT, as the semantic conventions suggest.
+func startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+	return tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query)),
+	)
+}
+
+// endSpan ends span, marking it failed if err is. driver.ErrSkip only asks
+// database/sql to try another way and is not a failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func traceExec(ctx context.Context, query string, exec func(context.Context) (driver.Result, error)) (driver.Result, error) {
+	ctx, span := startSpan(ctx, query)
+	result, err := exec(ctx)
+	if err == nil {
+		if n, rowsErr := result.RowsAffected(); rowsErr == nil {
+			span.SetAttributes(RowsAffectedKey.Int64(n))
+		}
+	}
+	endSpan(span, err)
+	return result, err
+}
+
+func traceQuery(ctx context.Context, query string, q func(context.Context) (driver.Rows, error)) (driver.Rows, error) {
+	ctx, span := startSpan(ctx, query)
+	rows, err := q(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+// tracedConn traces the statements run on a connection. It implements every
+// optional interface database/sql looks for, deferring to the wrapped
+// connection or doing what database/sql would do without it.
+type tracedConn struct {
+	driver.Conn
+}
+
+var (
+	_ driver.ConnPrepareContext = tracedConn{}
+	_ driver.ConnBeginTx        = tracedConn{}
+	_ driver.QueryerContext     = tracedConn{}
+	_ driver.ExecerContext      = tracedConn{}
+	_ driver.Pinger             = tracedConn{}
+	_ driver.SessionResetter    = tracedConn{}
+	_ driver.Validator          = tracedConn{}
+	_ driver.NamedValueChecker  = tracedConn{}
+)
+
+func (c tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isCopy(query) {
+		return stmt, nil
+	}
+	return tracedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	beginCtx, span := startSpan(ctx, "BEGIN")
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(beginCtx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(0) || opts.ReadOnly {
+		err = errors.New("sql: driver does not support non-default transaction options")
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return tracedTx{Tx: tx, ctx: ctx}, nil
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return traceQuery(ctx, query, func(ctx context.Context) (driver.Rows, error) {
+		return q.QueryContext(ctx, query, args)
+	})
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return traceExec(ctx, query, func(ctx context.Context) (driver.Result, error) {
+		return e.ExecContext(ctx, query, args)
+	})
+}
+
+func (c tracedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c tracedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkNamedValue(c.Conn, nv)
+}
+
+// tracedStmt traces the executions of a prepared statement.
+type tracedStmt struct {
+	driver.Stmt
+	query string
+}
+
+var (
+	_ driver.StmtQueryContext  = tracedStmt{}
+	_ driver.StmtExecContext   = tracedStmt{}
+	_ driver.NamedValueChecker = tracedStmt{}
+)
+
+func (s tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return traceQuery(ctx, s.query, func(ctx context.Context) (driver.Rows, error) {
+		if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return q.QueryContext(ctx, args)
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Query(values)
+	})
+}
+
+func (s tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return traceExec(ctx, s.query, func(ctx context.Context) (driver.Result, error) {
+		if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+			return e.ExecContext(ctx, args)
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Exec(values)
+	})
+}
+
+func (s tracedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return checkNamedValue(s.Stmt, nv)
+}
+
+// tracedRows counts the rows a query returns and ends its span once they have
+// been read.
+type tracedRows struct {
+	driver.Rows
+	span  trace.Span
+	count int64
+	err   error
+}
+
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.count++
+	case !errors.Is(err, io.EOF):
+		r.err = err
+	}
+	return err
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	r.span.SetAttributes(ReturnedRowsKey.Int64(r.count))
+	if r.err == nil {
+		r.err = err
+	}
+	endSpan(r.span, r.err)
+	return err
+}
+
+// tracedTx traces the end of a transaction. Its spans are siblings of the
+// statements run in it, children of the span the transaction began in.
+type tracedTx struct {
+	driver.Tx
+	ctx context.Context
+}
+
+func (t tracedTx) Commit() error {
+	_, span := startSpan(t.ctx, "COMMIT")
+	err := t.Tx.Commit()
+	endSpan(span, err)
+	return err
+}
+
+func (t tracedTx) Rollback() error {
+	_, span := startSpan(t.ctx, "ROLLBACK")
+	err := t.Tx.Rollback()
+	endSpan(span, err)
+	return err
+}
+
+// checkNamedValue lets v convert arguments if it can; driver.ErrSkip makes
+// database/sql fall back to its default conversion.
+func checkNamedValue(v any, nv *driver.NamedValue) error {
+	if c, ok := v.(driver.NamedValueChecker); ok {
+		return c.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
+
+func isCopy(query string) bool {
+	fields := strings.Fields(query)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "COPY")
+}
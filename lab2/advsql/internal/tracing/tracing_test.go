@@ -0,0 +1,174 @@
+package tracing_test
+
+import (
+	"advsql/internal/tracing"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dsnConnector opens connections through a driver by DSN, as sql.Open does.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+// setupTracing records the spans started during the test.
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func setupDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, mock, err := sqlmock.NewWithDSN(t.Name())
+	if err != nil {
+		t.Fatalf("Ошибка при создании мока: %v", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	db := sql.OpenDB(tracing.WrapConnector(dsnConnector{mockDB.Driver(), t.Name()}))
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestSQLSpans(t *testing.T) {
+	recorder := setupTracing(t)
+	db, mock := setupDB(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name FROM users").
+		WithArgs("secret").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a").AddRow(2, "b"))
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM users").WillReturnError(sql.ErrConnDone)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Ошибка при начале транзакции: %v", err)
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id, name FROM users WHERE name <> $1", "secret")
+	if err != nil {
+		t.Fatalf("Ошибка запроса: %v", err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET version = version + 1"); err != nil {
+		t.Fatalf("Ошибка изменения: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Ошибка фиксации: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM users"); err == nil {
+		t.Fatal("Ожидалась ошибка удаления")
+	}
+	parent.End()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Не все ожидания выполнены: %v", err)
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	want := []string{"BEGIN", "SELECT", "UPDATE", "COMMIT", "DELETE", "request"}
+	if len(names) != len(want) {
+		t.Fatalf("Неверные спаны: получили %v, ожидали %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Неверные спаны: получили %v, ожидали %v", names, want)
+		}
+	}
+
+	for _, span := range spans[:5] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Спан %s не вложен в спан запроса", span.Name())
+		}
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("Спан %s: неверный вид %v", span.Name(), span.SpanKind())
+		}
+	}
+
+	query := attributes(spans[1])
+	if got := query["db.statement"].AsString(); got != "SELECT id, name FROM users WHERE name <> $1" {
+		t.Errorf("Неверный db.statement: %q", got)
+	}
+	if got := query[tracing.ReturnedRowsKey].AsInt64(); got != 2 {
+		t.Errorf("Неверное число строк: получили %d, ожидали 2", got)
+	}
+	for _, kv := range spans[1].Attributes() {
+		if kv.Value.Emit() == "secret" {
+			t.Errorf("Аргумент запроса попал в спан: %v", kv)
+		}
+	}
+
+	if got := attributes(spans[2])[tracing.RowsAffectedKey].AsInt64(); got != 3 {
+		t.Errorf("Неверное число изменённых строк: получили %d, ожидали 3", got)
+	}
+	if spans[4].Status().Code != codes.Error {
+		t.Errorf("Ошибка не отмечена в спане: %v", spans[4].Status())
+	}
+}
+
+func TestMiddlewarePropagatesTraceContext(t *testing.T) {
+	recorder := setupTracing(t)
+
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware("/healthz"))
+	var got trace.SpanContext
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	})
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Запрос не продолжил след вызывающего: %s", got.TraceID())
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Неверное число спанов: получили %d, ожидали 1", len(spans))
+	}
+	if spans[0].Name() != "/users/{id}" || spans[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Неверный спан запроса: %s, родитель %s", spans[0].Name(), spans[0].Parent().SpanID())
+	}
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"advsql/internal/audit"
+	"advsql/internal/models"
+	"advsql/internal/problem"
+	"advsql/internal/services"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// withAuditInfo puts the caller's X-Actor and X-Request-ID headers into the
+// request context, where the store picks them up for the audit trail and
+// the logger adds them to every record. A request without an ID is given
+// one, and the ID is echoed in the response. X-Actor is taken as claimed:
+// the service has no authentication to check it against.
+func withAuditInfo(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		info := audit.Info{Actor: r.Header.Get("X-Actor"), RequestID: requestID}
+		next(w, r.WithContext(audit.NewContext(r.Context(), info)))
+	}
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// GetUserHistory lists the changes made to a user.
+// @Summary     Get user history
+// @Description Get the audit trail of a user, newest first: every create, update, delete and restore with the user before and after the change, who made it and in which request. Deleted users keep their history.
+// @Tags        audit
+// @Produce     json
+// @Param       id        path     int true  "User ID"
+// @Param       page      query    int false "Page number"
+// @Param       page_size query    int false "Page size"
+// @Success     200       {object} models.AuditListResponse
+// @Failure     400       {object} problem.Details "Invalid user ID"
+// @Failure     429       {object} problem.Details "Too many requests"
+// @Failure     500       {object} problem.Details "Internal server error"
+// @Failure     504       {object} problem.Details "Request timed out"
+// @Router      /users/{id}/history [get]
+func (h *UserHandler) GetUserHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	h.listAudit(w, r, services.AuditFilter{UserID: id})
+}
+
+// GetAudit lists changes to all users.
+// @Summary     Get audit feed
+// @Description Get the audit trail of all users, newest first, optionally filtered. since and until are RFC 3339 timestamps; since is inclusive and until exclusive.
+// @Tags        audit
+// @Produce     json
+// @Param       user_id    query    int    false "User ID"
+// @Param       action     query    string false "Action" Enums(create, update, delete, restore, bulk_load)
+// @Param       actor      query    string false "Claimed actor, as sent in X-Actor"
+// @Param       request_id query    string false "Request ID"
+// @Param       since      query    string false "Earliest change time"
+// @Param       until      query    string false "Latest change time"
+// @Param       page       query    int    false "Page number"
+// @Param       page_size  query    int    false "Page size"
+// @Success     200        {object} models.AuditListResponse
+// @Failure     400        {object} problem.Details "Invalid filter"
+// @Failure     429        {object} problem.Details "Too many requests"
+// @Failure     500        {object} problem.Details "Internal server error"
+// @Failure     504        {object} problem.Details "Request timed out"
+// @Router      /audit [get]
+func (h *UserHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Action:    query.Get("action"),
+		Actor:     query.Get("actor"),
+		RequestID: query.Get("request_id"),
+	}
+
+	if v := query.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		filter.UserID = id
+	}
+	if filter.Action != "" && !audit.ValidAction(filter.Action) {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid action")
+		return
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				problem.Write(w, r, http.StatusBadRequest, "Invalid "+name)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	h.listAudit(w, r, filter)
+}
+
+func (h *UserHandler) listAudit(w http.ResponseWriter, r *http.Request, filter services.AuditFilter) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	entries, totalCount, err := h.store.ListAudit(r.Context(), filter, page, pageSize)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	response := models.AuditListResponse{
+		Entries:    entries,
+		TotalItems: totalCount,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (totalCount + pageSize - 1) / pageSize,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
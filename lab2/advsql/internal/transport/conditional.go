@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"advsql/internal/services"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionETag returns the strong entity tag of a user version, so any
+// change to the user, which always bumps its version, changes the tag.
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// fieldsETag returns the strong entity tag of the fields of a user version.
+// Every projection is a representation of its own, so its tag is the
+// version followed by the fields in table order, e.g. "3-id+name"; the full
+// user keeps the plain version tag. The fields are not joined with commas,
+// which separate the tags of If-None-Match.
+func fieldsETag(version int, fields services.Fields) string {
+	if fields == nil {
+		return versionETag(version)
+	}
+	var names []string
+	for _, field := range services.UserFields {
+		if slices.Contains(fields, field) {
+			names = append(names, field)
+		}
+	}
+	return `"` + strconv.Itoa(version) + "-" + strings.Join(names, "+") + `"`
+}
+
+// expectedVersion returns the version a write request is conditioned on:
+// the entity tag in If-Match or, without that header, bodyVersion. ok is
+// false when the request names no version at all. "*" matches any version
+// and yields zero; a tag that is not one of ours can never match and
+// yields -1.
+func expectedVersion(r *http.Request, bodyVersion int) (version int, ok bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return bodyVersion, bodyVersion > 0
+	}
+	if ifMatch == "*" {
+		return 0, true
+	}
+	// If-Match uses the strong comparison, so weak tags never match.
+	if unquoted, err := strconv.Unquote(ifMatch); err == nil {
+		if v, err := strconv.Atoi(unquoted); err == nil && v > 0 {
+			return v, true
+		}
+	}
+	return -1, true
+}
+
+// checkNotModified sets the validators of a representation and, when the
+// request's conditional headers show the client already has it, writes 304
+// Not Modified and returns true. A zero lastModified is not advertised.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.2.2).
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches applies the weak comparison used by If-None-Match to a
+// comma separated list of entity tags.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package transport
+
+import (
+	"advsql/internal/problem"
+	"advsql/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// writeStoreError maps an error returned by a UserStore to an HTTP response.
+// Driver messages are logged rather than sent to the client.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	status, message := storeErrorStatus(r, err)
+	if status == http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "Request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	problem.Write(w, r, status, message)
+}
+
+// storeErrorStatus returns the HTTP status and client-facing message for an
+// error returned by a UserStore.
+func storeErrorStatus(r *http.Request, err error) (int, string) {
+	var constraintErr *services.ConstraintError
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		return http.StatusBadRequest, "Invalid cursor"
+	case errors.Is(err, services.ErrNotFound):
+		return http.StatusNotFound, "User not found"
+	case errors.Is(err, services.ErrNotDeleted):
+		return http.StatusConflict, "User is not deleted"
+	case errors.As(err, &constraintErr) && errors.Is(err, services.ErrConflict):
+		return http.StatusConflict, constraintErr.Message
+	case errors.As(err, &constraintErr) && errors.Is(err, services.ErrInvalid):
+		return http.StatusUnprocessableEntity, constraintErr.Message
+	case errors.Is(err, services.ErrConflict):
+		return http.StatusConflict, "Conflict"
+	case errors.Is(err, services.ErrInvalid):
+		return http.StatusUnprocessableEntity, "Invalid data"
+	case timedOut(r, err):
+		return http.StatusGatewayTimeout, "Request timed out"
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+}
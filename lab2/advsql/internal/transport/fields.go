@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"advsql/internal/models"
+	"advsql/internal/services"
+	"encoding/json"
+)
+
+// projectedListResponse is a UserListResponse whose users carry only the
+// requested fields.
+type projectedListResponse struct {
+	models.UserListResponse
+	Users []map[string]json.RawMessage `json:"users"`
+}
+
+// projectUser returns the JSON members of user named by fields. A requested
+// member the user omits, such as deleted_at of a live user, is null.
+func projectUser(user models.User, fields services.Fields) map[string]json.RawMessage {
+	data, _ := json.Marshal(user)
+	var members map[string]json.RawMessage
+	json.Unmarshal(data, &members)
+
+	view := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := members[field]; ok {
+			view[field] = value
+		} else {
+			view[field] = json.RawMessage("null")
+		}
+	}
+	return view
+}
+
+// encodeUsers writes a user listing, keeping only the requested fields of
+// every user unless fields is nil.
+func encodeUsers(enc *json.Encoder, response models.UserListResponse, fields services.Fields) error {
+	if fields == nil {
+		return enc.Encode(response)
+	}
+	projected := projectedListResponse{
+		UserListResponse: response,
+		Users:            make([]map[string]json.RawMessage, len(response.Users)),
+	}
+	for i, user := range response.Users {
+		projected.Users[i] = projectUser(user, fields)
+	}
+	return enc.Encode(projected)
+}
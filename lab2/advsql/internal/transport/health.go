@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"advsql/internal/health"
+	"advsql/internal/problem"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// HealthHandler serves the liveness and readiness endpoints.
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+// NewHealthHandler returns a HealthHandler whose readiness is decided by
+// checker.
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{checker: checker}
+}
+
+// RegisterHealthRoutes registers /healthz and /readyz. They are not subject
+// to RequireStarted, so they answer while the service is starting.
+func RegisterHealthRoutes(r *mux.Router, h *HealthHandler) {
+	r.HandleFunc("/healthz", h.GetHealthz).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", h.GetReadyz).Methods(http.MethodGet)
+}
+
+// GetHealthz reports that the process is alive.
+// @Summary     Liveness
+// @Description Reports that the process is up and serving HTTP. It does not look at any dependency, so a failing database does not get the service restarted.
+// @Tags        health
+// @Produce     json
+// @Success     200 {object} health.Report
+// @Router      /healthz [get]
+func (h *HealthHandler) GetHealthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, health.Report{Status: health.StatusOK})
+}
+
+// GetReadyz reports whether the service can take traffic.
+// @Summary     Readiness
+// @Description Pings the database and checks that the schema is up to date, with a timeout, and reports the status of each. Traffic should only be sent while this returns 200.
+// @Tags        health
+// @Produce     json
+// @Success     200 {object} health.Report
+// @Failure     503 {object} health.Report "A dependency is unavailable"
+// @Router      /readyz [get]
+func (h *HealthHandler) GetReadyz(w http.ResponseWriter, r *http.Request) {
+	report := h.checker.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// RequireStarted answers 503 until started is set, so no request reaches a
+// database that is still being prepared.
+func RequireStarted(started *health.Flag) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !started.IsSet() {
+				w.Header().Set("Retry-After", "5")
+				problem.Write(w, r, http.StatusServiceUnavailable, "The service is starting")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"advsql/internal/config"
+	"advsql/internal/models"
+	"advsql/internal/problem"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// importFields lists the user fields a CSV column can be mapped to, by the
+// name used in the map query parameter.
+var importFields = []string{"name", "age"}
+
+// importRow is a validated row of an imported CSV file.
+type importRow struct {
+	line int
+	user models.User
+}
+
+// csvImport is the parsed and validated content of an imported CSV file.
+type csvImport struct {
+	rows    []importRow
+	total   int
+	columns map[string]string
+	errors  []models.ImportError
+}
+
+func (c *csvImport) fail(line int, field, message string) {
+	c.errors = append(c.errors, models.ImportError{Line: line, Column: c.columns[field], Message: message})
+}
+
+// importMapping parses the map query parameters, each of the form
+// field:Header, into a map from user field to CSV header. Fields that are
+// not mapped default to a header with the field's own name.
+func importMapping(values []string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, value := range values {
+		field, header, ok := strings.Cut(value, ":")
+		field = strings.ToLower(strings.TrimSpace(field))
+		header = strings.TrimSpace(header)
+		if !ok || header == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected field:Header", value)
+		}
+		if !slices.Contains(importFields, field) {
+			return nil, fmt.Errorf("unknown field %q in column mapping", field)
+		}
+		mapping[field] = header
+	}
+	for _, field := range importFields {
+		if _, ok := mapping[field]; !ok {
+			mapping[field] = field
+		}
+	}
+	return mapping, nil
+}
+
+// readImport reads a CSV file with a header row and validates every row with
+// config.Validate. Problems with individual rows are collected in the result;
+// the returned error is only set when the file as a whole cannot be used.
+func readImport(body io.Reader, mapping map[string]string) (*csvImport, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	index := map[string]int{}
+	columns := map[string]string{}
+	for _, field := range importFields {
+		i := slices.IndexFunc(header, func(h string) bool {
+			return strings.EqualFold(strings.TrimSpace(h), mapping[field])
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("missing column %q for field %s", mapping[field], field)
+		}
+		index[field] = i
+		columns[field] = header[i]
+	}
+
+	result := &csvImport{columns: columns}
+	seen := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			result.total++
+			result.fail(parseErr.StartLine, "", parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.total++
+		line, _ := reader.FieldPos(0)
+
+		user := models.User{Name: strings.TrimSpace(record[index["name"]])}
+		valid := true
+		if age := strings.TrimSpace(record[index["age"]]); age != "" {
+			if user.Age, err = strconv.Atoi(age); err != nil {
+				result.fail(line, "age", "must be a whole number")
+				valid = false
+			}
+		}
+
+		var validationErrs validator.ValidationErrors
+		if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+			for _, fieldErr := range validationErrs {
+				result.fail(line, problem.Field(fieldErr), problem.Message(fieldErr))
+			}
+			valid = false
+		} else if err != nil {
+			return nil, err
+		}
+
+		if first, ok := seen[user.Name]; ok && user.Name != "" {
+			result.fail(line, "name", fmt.Sprintf("duplicates the name on line %d", first))
+			valid = false
+		} else {
+			seen[user.Name] = line
+		}
+
+		if valid {
+			result.rows = append(result.rows, importRow{line: line, user: user})
+		}
+	}
+	return result, nil
+}
+
+// ImportUsers creates users from a CSV file. The upload is bounded by the
+// route deadline rather than by READ_TIMEOUT: 5 minutes by default, or what
+// ROUTE_TIMEOUTS sets, e.g. ROUTE_TIMEOUTS="POST /users/import=15m".
+// @Summary     Import users from CSV
+// @Description Create users from a CSV file with a header row. Columns are matched to fields by header name, case-insensitively; use map=field:Header to read a field from a differently named column. Every row is validated and problems are reported by line, counting the header as line 1. By default nothing is written if any row is invalid; with atomic=false the valid rows are imported and the rest reported. dry_run=true only validates the file.
+// @Description The upload and the import together must finish within the route timeout (5 minutes by default, unless ROUTE_TIMEOUTS sets another for POST /users/import).
+// @Tags        users
+// @Accept      text/csv
+// @Produce     json
+// @Param       file    body     string   true  "CSV file with a header row"
+// @Param       map     query    []string false "Column mapping as field:Header, e.g. name:Full Name" collectionFormat(multi)
+// @Param       dry_run query    bool     false "Validate the file without writing anything"
+// @Param       atomic  query    bool     false "Set to false to import the valid rows when others fail"
+// @Param       X-Actor header   string   false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200  {object} models.ImportReport "Dry run report"
+// @Success     201  {object} models.ImportReport
+// @Success     207  {object} models.ImportReport "Some rows were not imported"
+// @Failure     400  {object} problem.Details "Invalid CSV file or column mapping"
+// @Failure     409  {object} problem.Details "User name already exists"
+// @Failure     415  {object} problem.Details "Unsupported content type"
+// @Failure     422  {object} models.ImportReport "Some rows are invalid"
+// @Failure     429  {object} problem.Details "Too many requests"
+// @Failure     500  {object} problem.Details "Internal server error"
+// @Failure     504  {object} problem.Details "Request timed out"
+// @Router      /users/import [post]
+func (h *UserHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	streamDeadlines(w, r)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "text/csv" {
+		problem.Write(w, r, http.StatusUnsupportedMediaType, "Content-Type must be text/csv")
+		return
+	}
+
+	query := r.URL.Query()
+	mapping, err := importMapping(query["map"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	file, err := readImport(r.Body, mapping)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid CSV file: "+err.Error())
+		return
+	}
+
+	report := models.ImportReport{
+		DryRun: query.Get("dry_run") == "true",
+		Rows:   file.total,
+		Valid:  len(file.rows),
+		Errors: file.errors,
+	}
+	users := make([]models.User, len(file.rows))
+	for i, row := range file.rows {
+		users[i] = row.user
+	}
+
+	status := http.StatusCreated
+	switch {
+	case report.DryRun:
+		status = http.StatusOK
+	case query.Get("atomic") == "false":
+		results, err := h.store.CreateEach(r.Context(), users)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		for i, result := range results {
+			if result.Err != nil {
+				_, message := storeErrorStatus(r, result.Err)
+				file.fail(file.rows[i].line, "", message)
+				continue
+			}
+			report.Imported++
+		}
+		report.Errors = file.errors
+		if len(report.Errors) > 0 {
+			status = http.StatusMultiStatus
+		}
+	case len(report.Errors) > 0:
+		status = http.StatusUnprocessableEntity
+	default:
+		if err := h.store.Create(r.Context(), users); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		report.Imported = len(users)
+	}
+
+	slices.SortStableFunc(report.Errors, func(a, b models.ImportError) int { return a.Line - b.Line })
+	if report.Errors == nil {
+		report.Errors = []models.ImportError{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
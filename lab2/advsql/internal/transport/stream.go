@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"advsql/internal/config"
+	"advsql/internal/models"
+	"advsql/internal/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// payloadError marks a malformed request body found while streaming it.
+type payloadError struct {
+	err error
+}
+
+func (e *payloadError) Error() string { return "invalid request payload: " + e.err.Error() }
+func (e *payloadError) Unwrap() error { return e.err }
+
+// invalidUserError marks a user of a stream that fails validation.
+type invalidUserError struct {
+	index int
+	errs  validator.ValidationErrors
+}
+
+func (e *invalidUserError) Error() string {
+	return fmt.Sprintf("invalid user %d: %v", e.index, e.errs)
+}
+
+// isNDJSON reports whether contentType announces newline delimited JSON.
+func isNDJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/x-ndjson" || mediaType == "application/ndjson"
+}
+
+// userStream decodes users from body one at a time, either from a JSON array
+// or from newline delimited JSON objects, without reading the whole body.
+// Every user is validated as it is decoded.
+func userStream(body io.Reader, ndjson bool) services.UserSource {
+	dec := json.NewDecoder(body)
+	started := false
+	index := 0
+	return func() (models.User, error) {
+		var user models.User
+		if !ndjson && !started {
+			started = true
+			tok, err := dec.Token()
+			if err != nil {
+				return user, &payloadError{err}
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return user, &payloadError{fmt.Errorf("expected a JSON array")}
+			}
+		}
+
+		if !ndjson && !dec.More() {
+			if _, err := dec.Token(); err != nil {
+				return user, &payloadError{err}
+			}
+			return user, io.EOF
+		}
+
+		if err := dec.Decode(&user); err != nil {
+			if ndjson && errors.Is(err, io.EOF) {
+				return user, io.EOF
+			}
+			return user, &payloadError{err}
+		}
+		var validationErrs validator.ValidationErrors
+		if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+			return user, &invalidUserError{index: index, errs: validationErrs}
+		}
+		index++
+		return user, nil
+	}
+}
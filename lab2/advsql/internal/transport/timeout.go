@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Timeouts holds the per-route request deadlines. Routes are keyed by
+// "METHOD /path/template"; routes without an entry use Default. A zero
+// duration disables the deadline.
+type Timeouts struct {
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+func (t Timeouts) route(method, path string) time.Duration {
+	if d, ok := t.Routes[method+" "+path]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// withTimeout cancels the request context after d. Every store call made
+// with that context aborts its SQL once the deadline passes.
+func withTimeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// timedOut reports whether err was caused by the request deadline. Drivers
+// do not always wrap context.DeadlineExceeded (lib/pq reports a cancelled
+// statement instead), so the request context is checked as well.
+func timedOut(r *http.Request, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// streamDeadlines gives a streaming request the route deadline on its
+// connection as well. The server's READ_TIMEOUT and WRITE_TIMEOUT would
+// otherwise close the connection of a large upload or download early,
+// whatever ROUTE_TIMEOUTS allows; without a route deadline they are
+// cleared. Writers that cannot set deadlines, as in tests, are left alone.
+func streamDeadlines(w http.ResponseWriter, r *http.Request) {
+	deadline, _ := r.Context().Deadline()
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+}
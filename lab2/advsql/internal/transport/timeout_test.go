@@ -0,0 +1,53 @@
+package transport_test
+
+import (
+	"advsql/internal/models"
+	"advsql/internal/services"
+	"advsql/internal/transport"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBulkLoadOutlastsReadTimeout(t *testing.T) {
+	store := services.NewMemoryUserStore()
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r, transport.NewUserHandler(store, transport.Timeouts{
+		Default: time.Second,
+		Routes:  map[string]time.Duration{"POST /users/bulk": 10 * time.Second},
+	}))
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// The body takes about three times the server's ReadTimeout to arrive.
+	body, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(pw, "{\"name\":\"user%d\",\"age\":%d}\n", i, 20+i)
+			time.Sleep(50 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+
+	resp, err := http.Post(srv.URL+"/users/bulk", "application/x-ndjson", body)
+	if err != nil {
+		t.Fatalf("Запрос оборвался: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Неверный статус: получили %d, ожидали %d", resp.StatusCode, http.StatusCreated)
+	}
+	var response models.BulkLoadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Inserted != 6 {
+		t.Errorf("Загружены не все пользователи: %+v, %v", response, err)
+	}
+}
@@ -2,26 +2,84 @@ package transport
 
 import (
 	_ "advsql/docs"
+	"advsql/internal/config"
+	"advsql/internal/metrics"
 	"advsql/internal/models"
+	"advsql/internal/patch"
+	"advsql/internal/problem"
 	"advsql/internal/services"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// UserHandler serves the /users endpoints from a UserStore.
+type UserHandler struct {
+	store      services.UserStore
+	timeouts   Timeouts
+	middleware []mux.MiddlewareFunc
+}
+
+// NewUserHandler returns a UserHandler backed by store whose requests are
+// cancelled after the deadlines in timeouts.
+func NewUserHandler(store services.UserStore, timeouts Timeouts) *UserHandler {
+	return &UserHandler{store: store, timeouts: timeouts}
+}
+
+// Use adds middleware to the routes registered afterwards. It runs inside
+// the route's metrics, timeout and request ID, so requests it refuses, e.g.
+// by RequireStarted or RateLimit, are measured and logged like the others.
+func (h *UserHandler) Use(mw ...mux.MiddlewareFunc) {
+	h.middleware = append(h.middleware, mw...)
+}
+
 // RegisterRoutes registers all routes for the application.
-func RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/users", GetUsers).Methods(http.MethodGet)
-	r.HandleFunc("/users", CreateUser).Methods(http.MethodPost)
-	r.HandleFunc("/users/{id}", UpdateUser).Methods(http.MethodPut)
-	r.HandleFunc("/users/{id}", DeleteUser).Methods(http.MethodDelete)
+func RegisterRoutes(r *mux.Router, h *UserHandler) {
+	h.handle(r, http.MethodGet, "/users", h.GetUsers)
+	h.handle(r, http.MethodPost, "/users", h.CreateUser)
+	h.handle(r, http.MethodPost, "/users/bulk", h.BulkLoadUsers)
+	h.handle(r, http.MethodPut, "/users", h.UpsertUsers)
+	h.handle(r, http.MethodGet, "/users/trash", h.GetTrash)
+	h.handle(r, http.MethodGet, "/users/export", h.ExportUsers)
+	h.handle(r, http.MethodPost, "/users/import", h.ImportUsers)
+	h.handle(r, http.MethodGet, "/users/stats", h.GetUserStats)
+	h.handle(r, http.MethodGet, "/users/{id}", h.GetUser)
+	h.handle(r, http.MethodPut, "/users/{id}", h.UpdateUser)
+	h.handle(r, http.MethodPatch, "/users/{id}", h.PatchUser)
+	h.handle(r, http.MethodDelete, "/users/{id}", h.DeleteUser)
+	h.handle(r, http.MethodPost, "/users/{id}/restore", h.RestoreUser)
+	h.handle(r, http.MethodGet, "/users/{id}/history", h.GetUserHistory)
+	h.handle(r, http.MethodGet, "/audit", h.GetAudit)
+
+	r.NotFoundHandler = withAuditInfo(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusNotFound, "No such resource")
+	})
+	r.MethodNotAllowedHandler = withAuditInfo(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on this resource")
+	})
+}
+
+func (h *UserHandler) handle(r *mux.Router, method, path string, fn http.HandlerFunc) {
+	var next http.Handler = fn
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		next = h.middleware[i](next)
+	}
+	r.Handle(path, metrics.Instrument(method, path, withTimeout(h.timeouts.route(method, path), withAuditInfo(next.ServeHTTP)))).Methods(method)
 }
 
 // GetUsers	Get list of users
 // @Summary Get list of users
 // @Description Get a paginated list of users with optional filters for age and sorting in ascending or descending order.
+// @Description Passing the cursor parameter (empty for the first page) switches to keyset pagination: no total count is computed and the response carries next_cursor/prev_cursor instead of page numbers.
 // @Tags users
 // @Accept  json
 // @Produce  json
@@ -29,16 +87,61 @@ func RegisterRoutes(r *mux.Router) {
 // @Param   max_age query int false "Maximum Age"
 // @Param   page query int false "Page number"
 // @Param   page_size query int false "Page size"
-// @Param   sort query string false "Sort by name in ascending or descending order"
+// @Param   sort query string false "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age; direction asc (default) or desc. Ties are broken by id."
+// @Param   cursor query string false "Opaque cursor from next_cursor or prev_cursor"
+// @Param   include_deleted query bool false "Also list users in the trash"
+// @Param   fields query string false "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all."
 // @Success 200 {object} models.UserListResponse
-// @Failure 500 {string} string "Internal Server Error"
+// @Failure 400 {object} problem.Details "Invalid cursor, sort or fields"
+// @Failure 429 {object} problem.Details "Too many requests"
+// @Failure 500 {object} problem.Details "Internal Server Error"
+// @Failure 504 {object} problem.Details "Request timed out"
 // @Router /users [get]
-func GetUsers(w http.ResponseWriter, r *http.Request) {
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	deleted := services.ExcludeDeleted
+	if include, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted")); include {
+		deleted = services.IncludeDeleted
+	}
+	h.listUsers(w, r, deleted)
+}
+
+// GetTrash lists deleted users.
+// @Summary     List deleted users
+// @Description Get a paginated list of soft-deleted users. Accepts the same filters, sorting and pagination as GET /users.
+// @Tags        users
+// @Produce     json
+// @Param       min_age   query    int    false "Minimum Age"
+// @Param       max_age   query    int    false "Maximum Age"
+// @Param       page      query    int    false "Page number"
+// @Param       page_size query    int    false "Page size"
+// @Param       sort      query    string false "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age; direction asc (default) or desc. Ties are broken by id."
+// @Param       cursor    query    string false "Opaque cursor from next_cursor or prev_cursor"
+// @Param       fields    query    string false "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all."
+// @Success     200       {object} models.UserListResponse
+// @Failure     400       {object} problem.Details "Invalid cursor, sort or fields"
+// @Failure     429       {object} problem.Details "Too many requests"
+// @Failure     500       {object} problem.Details "Internal Server Error"
+// @Failure     504       {object} problem.Details "Request timed out"
+// @Router      /users/trash [get]
+func (h *UserHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	h.listUsers(w, r, services.OnlyDeleted)
+}
+
+func (h *UserHandler) listUsers(w http.ResponseWriter, r *http.Request, deleted services.DeletedFilter) {
 	minAge, _ := strconv.Atoi(r.URL.Query().Get("min_age"))
 	maxAge, _ := strconv.Atoi(r.URL.Query().Get("max_age"))
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
-	sort := r.URL.Query().Get("sort")
+	sort, err := services.ParseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := services.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if page <= 0 {
 		page = 1
@@ -47,21 +150,20 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		pageSize = 10
 	}
 
-	users, totalCount, err := services.GetUsers(minAge, maxAge, page, pageSize, sort)
+	if r.URL.Query().Has("cursor") {
+		h.getUsersByCursor(w, r, minAge, maxAge, pageSize, sort, deleted, fields)
+		return
+	}
+
+	users, totalCount, err := h.store.List(r.Context(), minAge, maxAge, page, pageSize, sort, deleted, fields)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
 	totalPages := (totalCount + pageSize - 1) / pageSize
 
-	response := struct {
-		Users      []models.User `json:"users"`
-		TotalItems int           `json:"total_items"`
-		Page       int           `json:"page"`
-		PageSize   int           `json:"page_size"`
-		TotalPages int           `json:"total_pages"`
-	}{
+	response := models.UserListResponse{
 		Users:      users,
 		TotalItems: totalCount,
 		Page:       page,
@@ -70,111 +172,556 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	encodeUsers(json.NewEncoder(w), response, fields)
+}
+
+func (h *UserHandler) getUsersByCursor(w http.ResponseWriter, r *http.Request, minAge, maxAge, pageSize int, sort services.Sort, deleted services.DeletedFilter, fields services.Fields) {
+	users, next, prev, err := h.store.ListByCursor(r.Context(), minAge, maxAge, pageSize, sort, r.URL.Query().Get("cursor"), deleted, fields)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	response := models.UserListResponse{
+		Users:      users,
+		PageSize:   pageSize,
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encodeUsers(json.NewEncoder(w), response, fields)
+}
+
+// GetUser returns a single user.
+// @Summary     Get user
+// @Description Get a user by ID. The response carries a strong ETag derived from the user's data and the requested fields; sending it back in If-None-Match returns 304 Not Modified while the user is unchanged. Only the ETag of the whole user is accepted in If-Match by writes.
+// @Tags        users
+// @Produce     json
+// @Param       id            path     int    true  "User ID"
+// @Param       fields        query    string false "Comma separated fields to return, e.g. id,name. Fields: id, name, age, version, deleted_at. Defaults to all."
+// @Param       If-None-Match header   string false "ETag from a previous response"
+// @Success     200 {object} models.User
+// @Success     304 {string} string "Not Modified"
+// @Failure     400 {object} problem.Details "Invalid user ID or fields"
+// @Failure     404 {object} problem.Details "User not found"
+// @Failure     429 {object} problem.Details "Too many requests"
+// @Failure     500 {object} problem.Details "Internal server error"
+// @Failure     504 {object} problem.Details "Request timed out"
+// @Router      /users/{id} [get]
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	fields, err := services.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := h.store.Get(r.Context(), id, fields)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	if checkNotModified(w, r, fieldsETag(user.Version, fields), time.Time{}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if fields != nil {
+		json.NewEncoder(w).Encode(projectUser(user, fields))
+		return
+	}
+	json.NewEncoder(w).Encode(user)
 }
 
 // CreateUser creates a new users.
 // @Summary     Create few users at once
-// @Description Create a new users
+// @Description Create a new users. By default the batch is all-or-nothing and is refused with 422 if any user fails validation; with atomic=false every user is validated and inserted on its own and the response lists the outcome of each one.
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       user body     []models.User true "User to create"
+// @Param       user    body     []models.User true  "User to create"
+// @Param       atomic  query    bool          false "Set to false to keep valid rows when others fail"
+// @Param       X-Actor header   string        false "Claimed author of the change, recorded in the audit trail. Not authenticated."
 // @Success     201  {string} string "Created"
-// @Failure     400  {string} string "Invalid request payload"
-// @Failure     500  {string} string "Internal server error"
+// @Success     207  {object} models.BulkCreateResponse
+// @Failure     400  {object} problem.Details "Invalid request payload"
+// @Failure     409  {object} problem.Details "User name already exists"
+// @Failure     422  {object} problem.Details "Validation failed or data violates a constraint"
+// @Failure     429  {object} problem.Details "Too many requests"
+// @Failure     500  {object} problem.Details "Internal server error"
+// @Failure     504  {object} problem.Details "Request timed out"
 // @Router      /users [post]
-func CreateUser(w http.ResponseWriter, r *http.Request) {
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var users []models.User
 	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if r.URL.Query().Get("atomic") == "false" {
+		h.createUsersEach(w, r, users)
+		return
+	}
+
+	if errs := invalidUsers(users); errs != nil {
+		problem.Validation(w, r, http.StatusUnprocessableEntity, errs)
+		return
+	}
+	if err := h.store.Create(r.Context(), users); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *UserHandler) createUsersEach(w http.ResponseWriter, r *http.Request, users []models.User) {
+	response := models.BulkCreateResponse{Results: make([]models.BulkCreateResult, len(users))}
+	// Only the valid users are sent to the store; indexes maps their
+	// position in that batch back to the request body.
+	var valid []models.User
+	var indexes []int
+	for i, user := range users {
+		var validationErrs validator.ValidationErrors
+		if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+			response.Results[i] = models.BulkCreateResult{
+				Index:  i,
+				Status: http.StatusUnprocessableEntity,
+				Error:  validationMessage(validationErrs),
+			}
+			response.Failed++
+			continue
+		}
+		valid = append(valid, user)
+		indexes = append(indexes, i)
+	}
+
+	results, err := h.store.CreateEach(r.Context(), valid)
+	if err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
 
-	if err := services.CreateUser(users); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	for j, result := range results {
+		i := indexes[j]
+		item := models.BulkCreateResult{Index: i, Status: http.StatusCreated, ID: result.ID}
+		if result.Err != nil {
+			item.Status, item.Error = storeErrorStatus(r, result.Err)
+			item.ID = 0
+			response.Failed++
+		} else {
+			response.Created++
+		}
+		response.Results[i] = item
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+	json.NewEncoder(w).Encode(response)
+}
+
+// BulkLoadUsers streams a large batch of users into the database.
+// The whole request, upload included, is bounded by the route deadline
+// rather than by READ_TIMEOUT and WRITE_TIMEOUT: 5 minutes by default, or
+// what ROUTE_TIMEOUTS sets, e.g. ROUTE_TIMEOUTS="POST /users/bulk=15m".
+// @Summary     Bulk load users
+// @Description Stream users into the database with COPY. The body is either a JSON array or newline delimited JSON (Content-Type application/x-ndjson) and is decoded and validated while it is being copied. The load is all-or-nothing: the first invalid user fails it with 422.
+// @Description The upload and the load together must finish within the route timeout (5 minutes by default, unless ROUTE_TIMEOUTS sets another for POST /users/bulk); slower requests are cut off.
+// @Tags        users
+// @Accept      json
+// @Accept      application/x-ndjson
+// @Produce     json
+// @Param       user    body     []models.User true  "Users to load"
+// @Param       X-Actor header   string        false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     201     {object} models.BulkLoadResponse
+// @Failure     400     {object} problem.Details "Invalid request payload"
+// @Failure     409     {object} problem.Details "User name already exists"
+// @Failure     422     {object} problem.Details "Validation failed or data violates a constraint"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
+// @Router      /users/bulk [post]
+func (h *UserHandler) BulkLoadUsers(w http.ResponseWriter, r *http.Request) {
+	streamDeadlines(w, r)
+	start := time.Now()
+	src := userStream(r.Body, isNDJSON(r.Header.Get("Content-Type")))
+
+	inserted, err := h.store.BulkLoad(r.Context(), src)
+	if err != nil {
+		var payloadErr *payloadError
+		if errors.As(err, &payloadErr) {
+			problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		var invalid *invalidUserError
+		if errors.As(err, &invalid) {
+			problem.ValidationAt(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("[%d]", invalid.index), invalid.errs)
+			return
+		}
+		writeStoreError(w, r, err)
 		return
 	}
+
+	elapsed := time.Since(start)
+	response := models.BulkLoadResponse{
+		Inserted:   inserted,
+		DurationMS: elapsed.Milliseconds(),
+	}
+	if elapsed > 0 {
+		response.RowsPerSecond = float64(inserted) / elapsed.Seconds()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpsertUsers creates or updates users by name.
+// @Summary     Upsert users
+// @Description Create users whose name does not exist yet and update the age of those that do. The body is a list of users or a single user object. The batch is applied atomically and the response reports, per user, whether it was created or updated.
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       user    body     []models.User true  "Users to create or update"
+// @Param       X-Actor header   string        false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200     {object} models.UpsertResponse
+// @Failure     400     {object} problem.Details "Invalid request payload"
+// @Failure     422     {object} problem.Details "Validation failed or data violates a constraint"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
+// @Router      /users [put]
+func (h *UserHandler) UpsertUsers(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	var users []models.User
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var user models.User
+		if err := json.Unmarshal(raw, &user); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		users = []models.User{user}
+	} else if err := json.Unmarshal(raw, &users); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if errs := invalidUsers(users); errs != nil {
+		problem.Validation(w, r, http.StatusUnprocessableEntity, errs)
+		return
+	}
+
+	results, err := h.store.Upsert(r.Context(), users)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	response := models.UpsertResponse{Results: make([]models.UpsertResult, len(results))}
+	for i, result := range results {
+		action := "updated"
+		if result.Created {
+			action = "created"
+			response.Created++
+		} else {
+			response.Updated++
+		}
+		response.Results[i] = models.UpsertResult{Index: i, Action: action, User: result.User}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // UpdateUser updates an existing user.
 // @Summary     Update user
-// @Description Update user details by ID
+// @Description Update user details by ID. The write is conditional on the version the client last read, given as the ETag in If-Match or as the version field; if the user changed since, the current user is returned with 412.
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       id   path     int         true "User ID"
-// @Param       user body     models.User true "Updated user"
-// @Success     200  {object} models.User
-// @Failure     400  {string} string "Invalid request payload"
-// @Failure     404  {string} string "User not found"
-// @Failure     500  {string} string "Internal server error"
+// @Param       id       path     int         true  "User ID"
+// @Param       If-Match header   string      false "ETag of the version being updated"
+// @Param       user     body     models.User true  "Updated user"
+// @Param       X-Actor  header   string      false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200      {object} models.User
+// @Failure     400      {object} problem.Details "Invalid request payload"
+// @Failure     404      {object} problem.Details "User not found"
+// @Failure     409      {object} problem.Details "User name already exists"
+// @Failure     412      {object} models.User "User was modified; current user"
+// @Failure     422      {object} problem.Details "Validation failed or data violates a constraint"
+// @Failure     428      {object} problem.Details "If-Match header or version field required"
+// @Failure     429      {object} problem.Details "Too many requests"
+// @Failure     500      {object} problem.Details "Internal server error"
+// @Failure     504      {object} problem.Details "Request timed out"
 // @Router      /users/{id} [put]
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	var user models.User
 
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid input")
 		return
 	}
 	user.ID = id
 
-	userData, err := json.Marshal(user)
+	var validationErrs validator.ValidationErrors
+	if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+		problem.Validation(w, r, http.StatusUnprocessableEntity, validationErrs)
+		return
+	}
+
+	version, ok := expectedVersion(r, user.Version)
+	if !ok {
+		problem.Write(w, r, http.StatusPreconditionRequired, "If-Match header or version field required")
+		return
+	}
+	user.Version = version
+
+	slog.DebugContext(r.Context(), "Updating user", "user_id", user.ID, "version", user.Version)
+
+	user, err = h.store.Update(r.Context(), user)
+	if errors.Is(err, services.ErrVersionMismatch) {
+		h.writeCurrentUser(w, r, id, http.StatusPreconditionFailed)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error processing data", http.StatusInternalServerError)
+		writeStoreError(w, r, err)
 		return
 	}
 
-	log.Printf("Updating user: %s", userData)
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
 
-	if err := services.UpdateUser(user); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+// PatchUser partially updates an existing user.
+// @Summary     Patch user
+// @Description Update only the fields present in the patch. The body is either a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json) or a JSON Patch (RFC 6902, Content-Type application/json-patch+json). The patched user is validated again and written in a single transaction.
+// @Description The write is conditional on the version the client last read, given as the ETag in If-Match, as a version member of a merge patch or as a test operation on /version; if the user changed since, the current user is returned with 412.
+// @Tags        users
+// @Accept      application/merge-patch+json
+// @Accept      application/json-patch+json
+// @Produce     json
+// @Param       id       path     int    true  "User ID"
+// @Param       If-Match header   string false "ETag of the version being patched"
+// @Param       patch    body     object true  "Merge patch object or array of JSON Patch operations"
+// @Param       X-Actor  header   string false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200      {object} models.User
+// @Failure     400      {object} problem.Details "Malformed patch document"
+// @Failure     404      {object} problem.Details "User not found"
+// @Failure     409      {object} problem.Details "Test operation failed or user name already exists"
+// @Failure     412      {object} models.User "User was modified; current user"
+// @Failure     415      {object} problem.Details "Unsupported patch media type"
+// @Failure     422      {object} problem.Details "Patch cannot be applied or result is invalid"
+// @Failure     428      {object} problem.Details "If-Match header or version required"
+// @Failure     429      {object} problem.Details "Too many requests"
+// @Failure     500      {object} problem.Details "Internal server error"
+// @Failure     504      {object} problem.Details "Request timed out"
+// @Router      /users/{id} [patch]
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
+	apply, err := patch.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		problem.Write(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	version, ok := expectedVersion(r, patchVersion(body))
+	if !ok {
+		problem.Write(w, r, http.StatusPreconditionRequired, "If-Match header or version required")
+		return
+	}
+
+	user, err := h.store.Patch(r.Context(), id, version, func(user models.User) (models.User, error) {
+		return patchUser(user, apply, body)
+	})
+	if errors.Is(err, services.ErrVersionMismatch) {
+		h.writeCurrentUser(w, r, id, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writePatchError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// writeCurrentUser responds with status and the user as it is now, so a
+// client whose precondition failed can merge its change and retry.
+func (h *UserHandler) writeCurrentUser(w http.ResponseWriter, r *http.Request, id, status int) {
+	user, err := h.store.Get(r.Context(), id, nil)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(user)
 }
 
 // DeleteUser deletes a user.
 // @Summary     Delete user
-// @Description Delete user by ID
+// @Description Move a user to the trash by ID. It can be brought back with POST /users/{id}/restore.
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       id  path     int     true "User ID"
-// @Success     204 {string} string "No Content"
-// @Failure     400 {string} string "Invalid user ID"
-// @Failure     404 {string} string "User not found"
-// @Failure     500 {string} string "Internal server error"
+// @Param       id      path     int     true  "User ID"
+// @Param       X-Actor header   string  false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     204     {string} string "No Content"
+// @Failure     400     {object} problem.Details "Invalid user ID"
+// @Failure     404     {object} problem.Details "User not found"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
 // @Router      /users/{id} [delete]
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	if err := services.DeleteUser(id); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Error deleting user", http.StatusInternalServerError)
-		}
+	if err := h.store.Delete(r.Context(), id); err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreUser undeletes a user.
+// @Summary     Restore user
+// @Description Bring a user back from the trash by ID.
+// @Tags        users
+// @Produce     json
+// @Param       id      path     int     true  "User ID"
+// @Param       X-Actor header   string  false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200     {object} models.User
+// @Failure     400     {object} problem.Details "Invalid user ID"
+// @Failure     404     {object} problem.Details "User not found"
+// @Failure     409     {object} problem.Details "User is not deleted"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
+// @Router      /users/{id}/restore [post]
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := h.store.Restore(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// defaultBucketWidth is the width of the age histogram buckets, in years,
+// when the request does not set one.
+const defaultBucketWidth = 10
+
+// GetUserStats summarises user ages.
+// @Summary     User statistics
+// @Description Get the number of users, their minimum, maximum, mean and median age, age percentiles and an age histogram. Accepts the same filters as GET /users.
+// @Tags        users
+// @Produce     json
+// @Param       min_age         query    int  false "Minimum Age"
+// @Param       max_age         query    int  false "Maximum Age"
+// @Param       include_deleted query    bool false "Also count users in the trash"
+// @Param       bucket_width    query    int  false "Width of the histogram buckets in years (default 10)"
+// @Success     200  {object} models.UserStats
+// @Failure     400  {object} problem.Details "Invalid bucket width"
+// @Failure     429  {object} problem.Details "Too many requests"
+// @Failure     500  {object} problem.Details "Internal Server Error"
+// @Failure     504  {object} problem.Details "Request timed out"
+// @Router      /users/stats [get]
+func (h *UserHandler) GetUserStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minAge, _ := strconv.Atoi(query.Get("min_age"))
+	maxAge, _ := strconv.Atoi(query.Get("max_age"))
+
+	deleted := services.ExcludeDeleted
+	if include, _ := strconv.ParseBool(query.Get("include_deleted")); include {
+		deleted = services.IncludeDeleted
+	}
+
+	bucketWidth := defaultBucketWidth
+	if value := query.Get("bucket_width"); value != "" {
+		width, err := strconv.Atoi(value)
+		if err != nil || width <= 0 {
+			problem.Write(w, r, http.StatusBadRequest, "bucket_width must be a positive integer")
+			return
+		}
+		bucketWidth = width
+	}
+
+	stats, err := h.store.Stats(r.Context(), minAge, maxAge, deleted, bucketWidth)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// invalidUsers returns the validation rules broken by users, with field
+// paths starting at the user's index, e.g. [1].name, or nil if all are
+// valid.
+func invalidUsers(users []models.User) validator.ValidationErrors {
+	var validationErrs validator.ValidationErrors
+	errors.As(config.Validate.Var(users, "dive"), &validationErrs)
+	return validationErrs
+}
+
+// validationMessage describes the rules in errs in one line, for responses
+// that report an error per item.
+func validationMessage(errs validator.ValidationErrors) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = problem.Field(err) + " " + problem.Message(err)
+	}
+	return strings.Join(messages, "; ")
+}
@@ -1,40 +1,67 @@
 package transport_test
 
 import (
-	"advsql/internal/database"
 	"advsql/internal/models"
+	"advsql/internal/problem"
+	"advsql/internal/services"
 	"advsql/internal/transport"
 	"bytes"
-	"database/sql"
-	"database/sql/driver"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
-var mockDB sqlmock.Sqlmock
+func setupRouter(t *testing.T, users ...models.User) (*mux.Router, *services.MemoryUserStore) {
+	store := services.NewMemoryUserStore()
+	if len(users) > 0 {
+		if err := store.Create(context.Background(), users); err != nil {
+			t.Fatalf("Не удалось создать пользователей: %v", err)
+		}
+	}
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r, transport.NewUserHandler(store, transport.Timeouts{}))
+	return r, store
+}
+
+// blockingStore never answers a listing until the request is cancelled.
+type blockingStore struct {
+	*services.MemoryUserStore
+}
+
+func (s blockingStore) List(ctx context.Context, minAge, maxAge, page, pageSize int, sort services.Sort, deleted services.DeletedFilter, fields services.Fields) ([]models.User, int, error) {
+	<-ctx.Done()
+	return nil, 0, ctx.Err()
+}
 
-func setupMockDB(t *testing.T) {
-	var db *sql.DB
-	var err error
-	db, mockDB, err = sqlmock.New()
+func doRequest(t *testing.T, r *mux.Router, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("Не удалось сериализовать запрос: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &payload)
 	if err != nil {
-		t.Fatalf("Не удалось подключиться к sqlmock: %v", err)
+		t.Fatal(err)
 	}
-	database.DB = db
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
 }
 
 func TestGetUsers(t *testing.T) {
-	var err error
-	database.DB, mockDB, err = sqlmock.New()
-	if err != nil {
-		t.Fatalf("Error initializing mock database: %v", err)
-	}
-	defer database.DB.Close()
+	r, _ := setupRouter(t,
+		models.User{Name: "John Doe", Age: 25},
+		models.User{Name: "Jane Doe", Age: 30},
+		models.User{Name: "Old Timer", Age: 70},
+	)
 
 	minAge := 18
 	maxAge := 30
@@ -42,112 +69,544 @@ func TestGetUsers(t *testing.T) {
 	pageSize := 10
 	sort := "name_asc"
 
-	params := []driver.Value{minAge, maxAge}
+	rr := doRequest(t, r, "GET", fmt.Sprintf("/users?page=%d&page_size=%d&min_age=%d&max_age=%d&sort=%s", page, pageSize, minAge, maxAge, sort), nil)
 
-	countQuery := `SELECT COUNT\(\*\) FROM users WHERE age >= \$1 AND age <= \$2`
-	countRows := sqlmock.NewRows([]string{"count"}).AddRow(2)
-	mockDB.ExpectQuery(countQuery).
-		WithArgs(params...).
-		WillReturnRows(countRows)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
 
-	userRows := sqlmock.NewRows([]string{"id", "name", "age"}).
-		AddRow(1, "John Doe", 25).
-		AddRow(2, "Jane Doe", 30)
+	var response models.UserListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
 
-	expectedSelectQuery := `SELECT id, name, age FROM users WHERE age >= \$1 AND age <= \$2 ORDER BY name ASC LIMIT \$3 OFFSET \$4`
-	queryParams := append(params, driver.Value(pageSize), driver.Value((page-1)*pageSize))
+	if response.TotalItems != 2 || response.TotalPages != 1 || len(response.Users) != 2 {
+		t.Fatalf("Неверный ответ: %+v", response)
+	}
+	if response.Users[0].Name != "Jane Doe" || response.Users[1].Name != "John Doe" {
+		t.Errorf("Неверный порядок сортировки: %+v", response.Users)
+	}
+}
 
-	mockDB.ExpectQuery(expectedSelectQuery).
-		WithArgs(queryParams...).
-		WillReturnRows(userRows)
+func TestGetUsersWithCursor(t *testing.T) {
+	r, _ := setupRouter(t,
+		models.User{Name: "Bob", Age: 30},
+		models.User{Name: "Alice", Age: 25},
+		models.User{Name: "Carol", Age: 28},
+		models.User{Name: "Kid", Age: 10},
+	)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("/users?page=%d&page_size=%d&min_age=%d&max_age=%d&sort=%s", page, pageSize, minAge, maxAge, sort), nil)
-	if err != nil {
-		t.Fatal(err)
+	rr := doRequest(t, r, "GET", "/users?cursor=&page_size=2&min_age=18&sort=name_asc", nil)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
 	}
 
-	rr := httptest.NewRecorder()
+	var first models.UserListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&first); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(first.Users) != 2 || first.Users[0].Name != "Alice" || first.Users[1].Name != "Bob" {
+		t.Fatalf("Неверная первая страница: %+v", first.Users)
+	}
+	if first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("Неверные курсоры первой страницы: %+v", first)
+	}
+
+	rr = doRequest(t, r, "GET", "/users?page_size=2&min_age=18&sort=name_asc&cursor="+first.NextCursor, nil)
+	var second models.UserListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&second); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(second.Users) != 1 || second.Users[0].Name != "Carol" {
+		t.Fatalf("Неверная вторая страница: %+v", second.Users)
+	}
+	if second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("Неверные курсоры второй страницы: %+v", second)
+	}
+
+	rr = doRequest(t, r, "GET", "/users?page_size=2&min_age=18&sort=name_asc&cursor="+second.PrevCursor, nil)
+	var back models.UserListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&back); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(back.Users) != 2 || back.Users[0].Name != "Alice" || back.Users[1].Name != "Bob" {
+		t.Errorf("Неверная страница при возврате назад: %+v", back.Users)
+	}
+	if back.PrevCursor != "" || back.NextCursor == "" {
+		t.Errorf("Неверные курсоры при возврате назад: %+v", back)
+	}
+}
+
+func TestGetUsersMultiSort(t *testing.T) {
+	r, _ := setupRouter(t,
+		models.User{Name: "Bob", Age: 30},
+		models.User{Name: "Alice", Age: 25},
+		models.User{Name: "Carol", Age: 30},
+		models.User{Name: "Dave", Age: 25},
+	)
+	want := []string{"Bob", "Carol", "Alice", "Dave"}
+
+	rr := doRequest(t, r, "GET", "/users?sort=age:desc,name:asc", nil)
+	var response models.UserListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	var names []string
+	for _, user := range response.Users {
+		names = append(names, user.Name)
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("Неверный порядок сортировки: получили %v, ожидали %v", names, want)
+	}
+
+	names = nil
+	url := "/users?page_size=1&sort=age:desc,name:asc&cursor="
+	for range want {
+		rr := doRequest(t, r, "GET", url, nil)
+		var page models.UserListResponse
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatalf("Ошибка при декодировании ответа: %v", err)
+		}
+		for _, user := range page.Users {
+			names = append(names, user.Name)
+		}
+		url = "/users?page_size=1&sort=age:desc,name:asc&cursor=" + page.NextCursor
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("Неверный порядок при постраничном обходе: получили %v, ожидали %v", names, want)
+	}
+
+	for _, sort := range []string{"email", "age:sideways", "name,name"} {
+		if rr := doRequest(t, r, "GET", "/users?sort="+sort, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("sort=%s: неверный код статуса: получили %v, ожидали %v", sort, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUsersFields(t *testing.T) {
+	r, _ := setupRouter(t,
+		models.User{Name: "John Doe", Age: 25},
+		models.User{Name: "Jane Doe", Age: 30},
+	)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/users?fields=id,name&sort=name", `[{"id":2,"name":"Jane Doe"},{"id":1,"name":"John Doe"}]`},
+		{"/users?fields=name&cursor=&page_size=1", `[{"name":"John Doe"}]`},
+		{"/users/2?fields=age,deleted_at", `{"age":30,"deleted_at":null}`},
+	}
+	for _, tt := range tests {
+		rr := doRequest(t, r, "GET", tt.url, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: неверный код статуса: получили %v, ожидали %v", tt.url, rr.Code, http.StatusOK)
+		}
+		var body struct {
+			Users json.RawMessage `json:"users"`
+		}
+		got := bytes.TrimSpace(rr.Body.Bytes())
+		if strings.HasPrefix(tt.url, "/users?") {
+			if err := json.Unmarshal(got, &body); err != nil {
+				t.Fatalf("%s: ошибка при декодировании ответа: %v", tt.url, err)
+			}
+			got = body.Users
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: получили %s, ожидали %s", tt.url, got, tt.want)
+		}
+	}
+
+	for _, url := range []string{"/users?fields=id,email", "/users/1?fields=profile"} {
+		if rr := doRequest(t, r, "GET", url, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: неверный код статуса: получили %v, ожидали %v", url, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUsersWithInvalidCursor(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	rr := doRequest(t, r, "GET", "/users?cursor=not-a-cursor", nil)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetUsersTimeout(t *testing.T) {
+	store := blockingStore{services.NewMemoryUserStore()}
+	timeouts := transport.Timeouts{
+		Default: time.Minute,
+		Routes:  map[string]time.Duration{"GET /users": 10 * time.Millisecond},
+	}
 	r := mux.NewRouter()
-	r.HandleFunc("/users", transport.GetUsers)
+	transport.RegisterRoutes(r, transport.NewUserHandler(store, timeouts))
+
+	rr := doRequest(t, r, "GET", "/users", nil)
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusGatewayTimeout)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	rr := doRequest(t, r, "GET", "/users/1", nil)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Ответ не содержит ETag")
+	}
+
+	var user models.User
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if user.ID != 1 || user.Name != "John Doe" {
+		t.Errorf("Неверные данные пользователя: %+v", user)
+	}
+
+	req, _ := http.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotModified {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNotModified)
+	}
 
+	if _, err := store.Update(context.Background(), models.User{ID: 1, Name: "John Doe", Age: 26}); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+		t.Errorf("Неверный код статуса после изменения: получили %v, ожидали %v", status, http.StatusOK)
 	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("ETag не изменился после обновления пользователя")
+	}
+}
+
+func TestGetUserFieldsETag(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
 
-	var response struct {
-		Users      []models.User `json:"users"`
-		TotalItems int           `json:"total_items"`
-		Page       int           `json:"page"`
-		PageSize   int           `json:"page_size"`
-		TotalPages int           `json:"total_pages"`
+	get := func(url, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
 	}
 
-	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
-		t.Errorf("Ошибка при декодировании ответа: %v", err)
+	full := get("/users/1", "").Header().Get("ETag")
+	rr := get("/users/1?fields=name,id", "")
+	if etag := rr.Header().Get("ETag"); etag != `"1-id+name"` {
+		t.Errorf("Неверный ETag проекции: получили %v, ожидали %v", etag, `"1-id+name"`)
+	}
+
+	// A projection is a different representation, so the tag of the whole
+	// user does not validate it, while the same fields in any order do.
+	if rr := get("/users/1?fields=id,name", full); rr.Code != http.StatusOK {
+		t.Errorf("Неверный код статуса с ETag полного пользователя: получили %v, ожидали %v", rr.Code, http.StatusOK)
+	}
+	if rr := get("/users/1?fields=id,name", `"1-id+name"`); rr.Code != http.StatusNotModified {
+		t.Errorf("Неверный код статуса с ETag проекции: получили %v, ожидали %v", rr.Code, http.StatusNotModified)
+	}
+	if rr := get("/users/1", `"1-id+name"`); rr.Code != http.StatusOK {
+		t.Errorf("Неверный код статуса полного пользователя с ETag проекции: получили %v, ожидали %v", rr.Code, http.StatusOK)
 	}
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	rr := doRequest(t, r, "GET", "/users/42", nil)
 
-	if err := mockDB.ExpectationsWereMet(); err != nil {
-		t.Errorf("Ожидания не были выполнены: %v", err)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNotFound)
 	}
 }
 
 func TestCreateUser(t *testing.T) {
-	setupMockDB(t)
-
-	mockDB.ExpectBegin()
-	mockDB.ExpectPrepare("INSERT INTO users").
-		ExpectExec().
-		WithArgs("John Doe", 25).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mockDB.ExpectCommit()
+	r, store := setupRouter(t)
 
 	users := []models.User{
 		{Name: "John Doe", Age: 25},
 	}
-	payload, err := json.Marshal(users)
-	if err != nil {
-		t.Fatalf("Не удалось сериализовать пользователей: %v", err)
+	rr := doRequest(t, r, "POST", "/users", users)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusCreated)
 	}
 
-	req, err := http.NewRequest("POST", "/users", bytes.NewBuffer(payload))
+	stored, total, err := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if total != 1 || stored[0].Name != "John Doe" || stored[0].Age != 25 {
+		t.Errorf("Пользователь не был сохранён: %+v", stored)
+	}
+}
 
-	rr := httptest.NewRecorder()
-	r := mux.NewRouter()
-	r.HandleFunc("/users", transport.CreateUser)
-	r.ServeHTTP(rr, req)
+func TestCreateUserDuplicateName(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
 
-	if status := rr.Code; status != http.StatusCreated {
-		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusCreated)
+	rr := doRequest(t, r, "POST", "/users", []models.User{{Name: "John Doe", Age: 40}})
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusConflict)
 	}
 }
 
-func TestUpdateUser(t *testing.T) {
-	setupMockDB(t)
+// validationErrors checks that rr is a 422 validation problem and returns
+// its field errors.
+func validationErrors(t *testing.T, rr *httptest.ResponseRecorder) []problem.FieldError {
+	t.Helper()
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusUnprocessableEntity)
+	}
+	var details problem.Details
+	if err := json.NewDecoder(rr.Body).Decode(&details); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if details.Type != problem.ValidationType {
+		t.Errorf("Неверный тип проблемы: получили %q, ожидали %q", details.Type, problem.ValidationType)
+	}
+	return details.Errors
+}
 
-	mockDB.ExpectExec("UPDATE users SET").
-		WithArgs("Jane Doe", 30, 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+func TestCreateUserInvalid(t *testing.T) {
+	r, store := setupRouter(t)
 
-	user := models.User{Name: "Jane Doe", Age: 30}
-	payload, err := json.Marshal(user)
-	if err != nil {
-		t.Fatalf("Не удалось сериализовать пользователя: %v", err)
+	rr := doRequest(t, r, "POST", "/users", []models.User{{Name: "John Doe", Age: 25}, {Name: "", Age: -1}, {Name: "Old", Age: 151}})
+
+	want := []problem.FieldError{
+		{Field: "[1].name", Rule: "required", Message: "is required"},
+		{Field: "[1].age", Rule: "gte", Message: "must be at least 0"},
+		{Field: "[2].age", Rule: "lte", Message: "must be at most 150"},
+	}
+	if errs := validationErrors(t, rr); !slices.Equal(errs, want) {
+		t.Errorf("Неверные ошибки полей: получили %v, ожидали %v", errs, want)
+	}
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 0 {
+		t.Errorf("Пакет с невалидным пользователем сохранён: %v пользователей", total)
+	}
+}
+
+func TestCreateUserNonAtomic(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	users := []models.User{
+		{Name: "Jane Doe", Age: 30},
+		{Name: "John Doe", Age: 40},
+		{Name: "Baby Doe", Age: 1},
+	}
+	rr := doRequest(t, r, "POST", "/users?atomic=false", users)
+
+	if status := rr.Code; status != http.StatusMultiStatus {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusMultiStatus)
+	}
+
+	var response models.BulkCreateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.Created != 2 || response.Failed != 1 || len(response.Results) != 3 {
+		t.Fatalf("Неверный ответ: %+v", response)
+	}
+	if response.Results[1].Status != http.StatusConflict || response.Results[1].Error == "" || response.Results[1].ID != 0 {
+		t.Errorf("Неверный результат для дубликата: %+v", response.Results[1])
+	}
+	if response.Results[0].ID == 0 || response.Results[2].ID == 0 {
+		t.Errorf("Не получены ID созданных пользователей: %+v", response.Results)
+	}
+
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 3 {
+		t.Errorf("Ожидалось 3 пользователя, получили %v", total)
+	}
+}
+
+func TestCreateUserNonAtomicInvalid(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	users := []models.User{
+		{Name: "", Age: 30},
+		{Name: "John Doe", Age: 40},
+		{Name: "Baby Doe", Age: 1},
+	}
+	rr := doRequest(t, r, "POST", "/users?atomic=false", users)
+
+	var response models.BulkCreateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.Created != 1 || response.Failed != 2 || len(response.Results) != 3 {
+		t.Fatalf("Неверный ответ: %+v", response)
+	}
+	if got := response.Results[0]; got.Index != 0 || got.Status != http.StatusUnprocessableEntity || got.Error != "name is required" {
+		t.Errorf("Неверный результат для невалидного пользователя: %+v", got)
+	}
+	if got := response.Results[1]; got.Index != 1 || got.Status != http.StatusConflict {
+		t.Errorf("Неверный результат для дубликата: %+v", got)
+	}
+	if got := response.Results[2]; got.Index != 2 || got.Status != http.StatusCreated || got.ID == 0 {
+		t.Errorf("Неверный результат для созданного пользователя: %+v", got)
+	}
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 2 {
+		t.Errorf("Ожидалось 2 пользователя, получили %v", total)
+	}
+}
+
+func TestBulkLoadUsers(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json array", "application/json", `[{"name":"John Doe","age":25},{"name":"Jane Doe","age":30}]`},
+		{"ndjson", "application/x-ndjson", "{\"name\":\"John Doe\",\"age\":25}\n{\"name\":\"Jane Doe\",\"age\":30}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, store := setupRouter(t)
+
+			req, err := http.NewRequest("POST", "/users/bulk", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusCreated {
+				t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusCreated)
+			}
+
+			var response models.BulkLoadResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Ошибка при декодировании ответа: %v", err)
+			}
+			if response.Inserted != 2 {
+				t.Errorf("Ожидалось 2 вставленных строки, получили %v", response.Inserted)
+			}
+			if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 2 {
+				t.Errorf("Ожидалось 2 пользователя, получили %v", total)
+			}
+		})
 	}
+}
 
-	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(payload))
+func TestBulkLoadUsersInvalidPayload(t *testing.T) {
+	r, store := setupRouter(t)
+
+	req, err := http.NewRequest("POST", "/users/bulk", strings.NewReader(`[{"name":"John Doe","age":25}, {"name":`))
 	if err != nil {
 		t.Fatal(err)
 	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 0 {
+		t.Errorf("Загрузка должна быть атомарной, но сохранено %v пользователей", total)
+	}
+}
+
+func TestBulkLoadUsersInvalidUser(t *testing.T) {
+	r, store := setupRouter(t)
+
+	body := "{\"name\":\"John Doe\",\"age\":25}\n{\"name\":\"Jane Doe\",\"age\":-1}\n"
+	req, err := http.NewRequest("POST", "/users/bulk", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
 	rr := httptest.NewRecorder()
-	r := mux.NewRouter()
-	r.HandleFunc("/users/{id}", transport.UpdateUser)
 	r.ServeHTTP(rr, req)
 
+	want := []problem.FieldError{{Field: "[1].age", Rule: "gte", Message: "must be at least 0"}}
+	if errs := validationErrors(t, rr); !slices.Equal(errs, want) {
+		t.Errorf("Неверные ошибки полей: получили %v, ожидали %v", errs, want)
+	}
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 0 {
+		t.Errorf("Загрузка должна быть атомарной, но сохранено %v пользователей", total)
+	}
+}
+
+func TestUpsertUsers(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	users := []models.User{
+		{Name: "John Doe", Age: 26},
+		{Name: "Jane Doe", Age: 30},
+	}
+	rr := doRequest(t, r, "PUT", "/users", users)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+
+	var response models.UpsertResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.Created != 1 || response.Updated != 1 {
+		t.Fatalf("Неверный ответ: %+v", response)
+	}
+	if response.Results[0].Action != "updated" || response.Results[0].User.ID != 1 || response.Results[0].User.Age != 26 {
+		t.Errorf("Неверный результат обновления: %+v", response.Results[0])
+	}
+	if response.Results[1].Action != "created" || response.Results[1].User.ID == 0 {
+		t.Errorf("Неверный результат создания: %+v", response.Results[1])
+	}
+
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 2 {
+		t.Errorf("Ожидалось 2 пользователя, получили %v", total)
+	}
+}
+
+func TestUpsertSingleUser(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	rr := doRequest(t, r, "PUT", "/users", models.User{Name: "John Doe", Age: 25})
+
+	var response models.UpsertResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(response.Results) != 1 || response.Results[0].Action != "created" {
+		t.Errorf("Неверный ответ: %+v", response)
+	}
+}
+
+func TestUpsertUsersInvalid(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	rr := doRequest(t, r, "PUT", "/users", []models.User{{Name: "John Doe", Age: -5}})
+
+	want := []problem.FieldError{{Field: "[0].age", Rule: "gte", Message: "must be at least 0"}}
+	if errs := validationErrors(t, rr); !slices.Equal(errs, want) {
+		t.Errorf("Неверные ошибки полей: получили %v, ожидали %v", errs, want)
+	}
+	if user, err := store.Get(context.Background(), 1, nil); err != nil || user.Age != 25 {
+		t.Errorf("Пользователь изменён невалидными данными: %+v, %v", user, err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	rr := doRequest(t, r, "PUT", "/users/1", models.User{Name: "Jane Doe", Age: 30, Version: 1})
+
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
 	}
@@ -157,29 +616,685 @@ func TestUpdateUser(t *testing.T) {
 		t.Errorf("Ошибка при декодировании ответа: %v", err)
 	}
 
-	if updatedUser.Name != "Jane Doe" || updatedUser.Age != 30 {
+	if updatedUser.Name != "Jane Doe" || updatedUser.Age != 30 || updatedUser.Version != 2 {
 		t.Errorf("Неверные данные пользователя: получили %v", updatedUser)
 	}
+	if etag := rr.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("Неверный ETag: получили %v, ожидали %v", etag, `"2"`)
+	}
 }
 
-func TestDeleteUser(t *testing.T) {
-	setupMockDB(t)
+func TestUpdateUserInvalid(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	rr := doRequest(t, r, "PUT", "/users/1", models.User{Name: strings.Repeat("x", 256), Age: 30, Version: 1})
+
+	want := []problem.FieldError{{Field: "name", Rule: "max", Message: "must be at most 255 characters long"}}
+	if errs := validationErrors(t, rr); !slices.Equal(errs, want) {
+		t.Errorf("Неверные ошибки полей: получили %v, ожидали %v", errs, want)
+	}
+	if user, err := store.Get(context.Background(), 1, nil); err != nil || user.Name != "John Doe" || user.Version != 1 {
+		t.Errorf("Пользователь изменён невалидными данными: %+v, %v", user, err)
+	}
+}
+
+func TestUpdateUserPreconditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		version int
+		status  int
+	}{
+		{"if-match", `"1"`, 0, http.StatusOK},
+		{"any version", "*", 0, http.StatusOK},
+		{"stale if-match", `"2"`, 0, http.StatusPreconditionFailed},
+		{"weak if-match", `W/"1"`, 0, http.StatusPreconditionFailed},
+		{"stale body version", "", 2, http.StatusPreconditionFailed},
+		{"no precondition", "", 0, http.StatusPreconditionRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+			var payload bytes.Buffer
+			json.NewEncoder(&payload).Encode(models.User{Name: "Jane Doe", Age: 30, Version: tt.version})
+			req, err := http.NewRequest("PUT", "/users/1", &payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, tt.status)
+			}
+			if tt.status != http.StatusPreconditionFailed {
+				return
+			}
+
+			var current models.User
+			if err := json.NewDecoder(rr.Body).Decode(&current); err != nil {
+				t.Fatalf("Ошибка при декодировании ответа: %v", err)
+			}
+			if current.Name != "John Doe" || current.Version != 1 {
+				t.Errorf("Ожидалось текущее представление пользователя, получили %v", current)
+			}
+			if etag := rr.Header().Get("ETag"); etag != `"1"` {
+				t.Errorf("Неверный ETag: получили %v, ожидали %v", etag, `"1"`)
+			}
+		})
+	}
+}
+
+func TestUpdateUserNotFound(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	rr := doRequest(t, r, "PUT", "/users/42", models.User{Name: "Jane Doe", Age: 30, Version: 1})
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNotFound)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	unchanged := models.User{ID: 1, Name: "John Doe", Age: 25, Version: 1}
+	tests := []struct {
+		name        string
+		contentType string
+		ifMatch     string
+		body        string
+		status      int
+		want        models.User
+	}{
+		{"merge patch", "application/merge-patch+json", `"1"`, `{"age":31}`, http.StatusOK, models.User{ID: 1, Name: "John Doe", Age: 31, Version: 2}},
+		{"json patch", "application/json-patch+json", `"1"`, `[{"op":"test","path":"/name","value":"John Doe"},{"op":"replace","path":"/name","value":"Johnny"}]`, http.StatusOK, models.User{ID: 1, Name: "Johnny", Age: 25, Version: 2}},
+		{"merge patch version", "application/merge-patch+json", "", `{"version":1,"age":31}`, http.StatusOK, models.User{ID: 1, Name: "John Doe", Age: 31, Version: 2}},
+		{"json patch version", "application/json-patch+json", "", `[{"op":"test","path":"/version","value":1},{"op":"replace","path":"/age","value":31}]`, http.StatusOK, models.User{ID: 1, Name: "John Doe", Age: 31, Version: 2}},
+		{"no change", "application/merge-patch+json", `"1"`, `{"age":25}`, http.StatusOK, unchanged},
+		{"stale version", "application/merge-patch+json", `"2"`, `{"age":31}`, http.StatusPreconditionFailed, unchanged},
+		{"no precondition", "application/merge-patch+json", "", `{"age":31}`, http.StatusPreconditionRequired, unchanged},
+		{"failed test", "application/json-patch+json", `"1"`, `[{"op":"test","path":"/age","value":99},{"op":"replace","path":"/age","value":1}]`, http.StatusConflict, unchanged},
+		{"malformed", "application/merge-patch+json", `"1"`, `{"age":`, http.StatusBadRequest, unchanged},
+		{"invalid result", "application/merge-patch+json", `"1"`, `{"name":null}`, http.StatusUnprocessableEntity, unchanged},
+		{"read-only id", "application/merge-patch+json", `"1"`, `{"id":2}`, http.StatusUnprocessableEntity, unchanged},
+		{"duplicate name", "application/merge-patch+json", `"1"`, `{"name":"Jane Doe"}`, http.StatusConflict, unchanged},
+		{"unsupported type", "application/json", `"1"`, `{"age":31}`, http.StatusUnsupportedMediaType, unchanged},
+	}
 
-	mockDB.ExpectExec("DELETE FROM users WHERE").
-		WithArgs(1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, store := setupRouter(t,
+				models.User{Name: "John Doe", Age: 25},
+				models.User{Name: "Jane Doe", Age: 30},
+			)
 
-	req, err := http.NewRequest("DELETE", "/users/1", nil)
+			req, err := http.NewRequest("PATCH", "/users/1", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.status {
+				t.Errorf("Неверный код статуса: получили %v, ожидали %v (%s)", status, tt.status, rr.Body)
+			}
+
+			user, err := store.Get(context.Background(), 1, nil)
+			if err != nil {
+				t.Fatalf("Не удалось получить пользователя: %v", err)
+			}
+			if user != tt.want {
+				t.Errorf("Неверные данные пользователя: получили %v, ожидали %v", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchUserNotFound(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	req, err := http.NewRequest("PATCH", "/users/42", strings.NewReader(`{"age":31}`))
 	if err != nil {
 		t.Fatal(err)
 	}
-
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
 	rr := httptest.NewRecorder()
-	r := mux.NewRouter()
-	r.HandleFunc("/users/{id}", transport.DeleteUser)
 	r.ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNotFound)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	tests := []struct {
+		method, url, contentType, body string
+		status                         int
+		wantType                       string
+		wantErrors                     []problem.FieldError
+	}{
+		{"GET", "/users/abc?fields=id", "", "", http.StatusBadRequest, "about:blank", nil},
+		{"GET", "/users/42", "", "", http.StatusNotFound, "about:blank", nil},
+		{"GET", "/nowhere", "", "", http.StatusNotFound, "about:blank", nil},
+		{"POST", "/users/1", "", "", http.StatusMethodNotAllowed, "about:blank", nil},
+		{"PATCH", "/users/1", "application/merge-patch+json", `{"name":"","age":-1}`, http.StatusUnprocessableEntity, problem.ValidationType, []problem.FieldError{
+			{Field: "name", Rule: "required", Message: "is required"},
+			{Field: "age", Rule: "gte", Message: "must be at least 0"},
+		}},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tt.contentType)
+		req.Header.Set("If-Match", `"1"`)
+		req.Header.Set("X-Request-ID", "req-1")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+			t.Errorf("%s %s: неверный Content-Type: получили %q, ожидали %q", tt.method, tt.url, ct, problem.ContentType)
+		}
+		var details problem.Details
+		if err := json.NewDecoder(rr.Body).Decode(&details); err != nil {
+			t.Fatalf("%s %s: ошибка при декодировании ответа: %v", tt.method, tt.url, err)
+		}
+		if rr.Code != tt.status || details.Status != tt.status {
+			t.Errorf("%s %s: неверный код статуса: получили %d (%d в теле), ожидали %d", tt.method, tt.url, rr.Code, details.Status, tt.status)
+		}
+		if details.Type != tt.wantType || details.Instance != tt.url || details.RequestID != "req-1" {
+			t.Errorf("%s %s: неверные детали проблемы: %+v", tt.method, tt.url, details)
+		}
+		if !slices.Equal(details.Errors, tt.wantErrors) {
+			t.Errorf("%s %s: неверные ошибки полей: получили %v, ожидали %v", tt.method, tt.url, details.Errors, tt.wantErrors)
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	rr := doRequest(t, r, "DELETE", "/users/1", nil)
+
 	if status := rr.Code; status != http.StatusNoContent {
 		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNoContent)
 	}
+
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 0 {
+		t.Errorf("Пользователь не был удалён")
+	}
+
+	rr = doRequest(t, r, "GET", "/users/1", nil)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Неверный код статуса для удалённого пользователя: получили %v, ожидали %v", status, http.StatusNotFound)
+	}
+}
+
+func TestGetTrash(t *testing.T) {
+	r, store := setupRouter(t,
+		models.User{Name: "John Doe", Age: 25},
+		models.User{Name: "Jane Doe", Age: 30},
+	)
+	if err := store.Delete(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"/users", []string{"Jane Doe"}},
+		{"/users?include_deleted=true", []string{"John Doe", "Jane Doe"}},
+		{"/users/trash", []string{"John Doe"}},
+	}
+
+	for _, tt := range tests {
+		rr := doRequest(t, r, "GET", tt.url, nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: неверный код статуса: получили %v, ожидали %v", tt.url, status, http.StatusOK)
+		}
+
+		var response models.UserListResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Ошибка при декодировании ответа: %v", err)
+		}
+		var names []string
+		for _, u := range response.Users {
+			names = append(names, u.Name)
+			if (u.DeletedAt != nil) != (u.Name == "John Doe") {
+				t.Errorf("%s: неверное поле deleted_at у %v", tt.url, u)
+			}
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%s: получили %v, ожидали %v", tt.url, names, tt.want)
+		}
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+	if err := store.Delete(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doRequest(t, r, "POST", "/users/1/restore", nil)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	if _, err := store.Get(context.Background(), 1, nil); err != nil {
+		t.Errorf("Пользователь не был восстановлен: %v", err)
+	}
+
+	rr = doRequest(t, r, "POST", "/users/1/restore", nil)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("Неверный код статуса для активного пользователя: получили %v, ожидали %v", status, http.StatusConflict)
+	}
+
+	rr = doRequest(t, r, "POST", "/users/42/restore", nil)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusNotFound)
+	}
+}
+
+func TestUserHistory(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "John Doe", Age: 25})
+
+	req := httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"name":"John Doe","age":26,"version":1}`))
+	req.Header.Set("X-Actor", "alice")
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса обновления: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	if id := rr.Header().Get("X-Request-ID"); id != "req-1" {
+		t.Errorf("Неверный X-Request-ID: получили %q, ожидали %q", id, "req-1")
+	}
+
+	if rr := doRequest(t, r, "DELETE", "/users/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Неверный код статуса удаления: %v", rr.Code)
+	}
+	// An upsert by name brings the user back with a new age.
+	if rr := doRequest(t, r, "PUT", "/users", []models.User{{Name: "John Doe", Age: 27}}); rr.Code != http.StatusOK {
+		t.Fatalf("Неверный код статуса upsert: %v", rr.Code)
+	}
+
+	rr = doRequest(t, r, "GET", "/users/1/history", nil)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	var response models.AuditListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+
+	var actions []string
+	for _, e := range response.Entries {
+		actions = append(actions, e.Action)
+	}
+	if want := []string{"restore", "delete", "update", "create"}; !slices.Equal(actions, want) {
+		t.Fatalf("Неверная история: получили %v, ожидали %v", actions, want)
+	}
+
+	var deleted, restored models.User
+	if err := json.Unmarshal(response.Entries[0].Before, &deleted); err != nil {
+		t.Fatalf("Восстановление записано без состояния до: %v", err)
+	}
+	if err := json.Unmarshal(response.Entries[0].After, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.DeletedAt == nil || deleted.Age != 26 || restored.DeletedAt != nil || restored.Age != 27 {
+		t.Errorf("Неверные состояния восстановления: %+v -> %+v", deleted, restored)
+	}
+
+	update := response.Entries[2]
+	if update.Actor != "alice" || update.RequestID != "req-1" {
+		t.Errorf("Неверный автор изменения: %+v", update)
+	}
+	var before, after models.User
+	if err := json.Unmarshal(update.Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(update.After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before.Age != 25 || after.Age != 26 {
+		t.Errorf("Неверные состояния до и после: %+v -> %+v", before, after)
+	}
+	if response.Entries[1].After != nil || response.Entries[1].RequestID == "" {
+		t.Errorf("Неверная запись удаления: %+v", response.Entries[1])
+	}
+}
+
+func TestBulkLoadAudit(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	req := httptest.NewRequest("POST", "/users/bulk", strings.NewReader(`[{"name":"John Doe","age":25},{"name":"Jane Doe","age":30}]`))
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("Неверный код статуса загрузки: получили %v, ожидали %v", status, http.StatusCreated)
+	}
+
+	rr = doRequest(t, r, "GET", "/audit", nil)
+	var response models.AuditListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(response.Entries) != 3 {
+		t.Fatalf("Ожидались записи о каждом пользователе и о загрузке, получили %+v", response.Entries)
+	}
+	entry := response.Entries[0]
+	if entry.Action != "bulk_load" || entry.UserID != 0 || entry.RequestID != "req-1" || string(entry.After) != `{"inserted":2}` {
+		t.Errorf("Неверная запись загрузки: %+v, after %s", entry, entry.After)
+	}
+
+	rr = doRequest(t, r, "GET", "/users/2/history", nil)
+	response = models.AuditListResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(response.Entries) != 1 || response.Entries[0].Action != "create" || response.Entries[0].RequestID != "req-1" {
+		t.Errorf("Загруженный пользователь без записи о создании: %+v", response.Entries)
+	}
+}
+
+func TestGetAudit(t *testing.T) {
+	r, store := setupRouter(t,
+		models.User{Name: "John Doe", Age: 25},
+		models.User{Name: "Jane Doe", Age: 30},
+	)
+	if err := store.Delete(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url   string
+		total int
+	}{
+		{"/audit", 3},
+		{"/audit?action=create", 2},
+		{"/audit?user_id=2", 2},
+		{"/audit?user_id=2&action=delete", 1},
+		{"/audit?since=2000-01-01T00:00:00Z&until=2000-01-02T00:00:00Z", 0},
+	}
+
+	for _, tt := range tests {
+		rr := doRequest(t, r, "GET", tt.url, nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: неверный код статуса: получили %v, ожидали %v", tt.url, status, http.StatusOK)
+		}
+		var response models.AuditListResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Ошибка при декодировании ответа: %v", err)
+		}
+		if response.TotalItems != tt.total || len(response.Entries) != tt.total {
+			t.Errorf("%s: получили %v записей, ожидали %v", tt.url, response.TotalItems, tt.total)
+		}
+	}
+
+	for _, url := range []string{"/audit?action=purge", "/audit?user_id=x", "/audit?since=yesterday"} {
+		if rr := doRequest(t, r, "GET", url, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: неверный код статуса: получили %v, ожидали %v", url, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	r, _ := setupRouter(t,
+		models.User{Name: "John Doe", Age: 25},
+		models.User{Name: "Jane Doe", Age: 30},
+		models.User{Name: "Old Timer", Age: 70},
+	)
+
+	tests := []struct {
+		url         string
+		contentType string
+		want        string
+	}{
+		{"/users/export?max_age=50&sort=name_asc", "text/csv; charset=utf-8",
+			"id,name,age,version\n2,Jane Doe,30,1\n1,John Doe,25,1\n"},
+		{"/users/export?format=ndjson&min_age=26", "application/x-ndjson",
+			`{"id":2,"name":"Jane Doe","age":30,"version":1}` + "\n" + `{"id":3,"name":"Old Timer","age":70,"version":1}` + "\n"},
+		{"/users/export?format=json&min_age=26&sort=name_desc", "application/json",
+			`[{"id":3,"name":"Old Timer","age":70,"version":1},{"id":2,"name":"Jane Doe","age":30,"version":1}]` + "\n"},
+		{"/users/export?format=json&min_age=100", "application/json", "[]\n"},
+	}
+
+	for _, tt := range tests {
+		rr := doRequest(t, r, "GET", tt.url, nil)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: неверный код статуса: получили %v, ожидали %v", tt.url, status, http.StatusOK)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: неверный Content-Type: получили %q, ожидали %q", tt.url, ct, tt.contentType)
+		}
+		if body := rr.Body.String(); body != tt.want {
+			t.Errorf("%s: неверное тело ответа:\nполучили %q\nожидали  %q", tt.url, body, tt.want)
+		}
+	}
+
+	if rr := doRequest(t, r, "GET", "/users/export?format=xml", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportUsersCSVFormulas(t *testing.T) {
+	r, _ := setupRouter(t,
+		models.User{Name: "=HYPERLINK(\"http://evil\")", Age: 25},
+		models.User{Name: "+1", Age: 26},
+		models.User{Name: "-2", Age: 27},
+		models.User{Name: "@SUM(A1)", Age: 28},
+		models.User{Name: "\tTab", Age: 29},
+		models.User{Name: "Jane-Doe", Age: 30},
+	)
+
+	rr := doRequest(t, r, "GET", "/users/export?sort=age", nil)
+	want := "id,name,age,version\n" +
+		"1,\"'=HYPERLINK(\"\"http://evil\"\")\",25,1\n" +
+		"2,'+1,26,1\n" +
+		"3,'-2,27,1\n" +
+		"4,'@SUM(A1),28,1\n" +
+		"5,'\tTab,29,1\n" +
+		"6,Jane-Doe,30,1\n"
+	if body := rr.Body.String(); body != want {
+		t.Errorf("Неверное тело ответа:\nполучили %q\nожидали  %q", body, want)
+	}
+}
+
+func importCSV(t *testing.T, r *mux.Router, url, body string) (*httptest.ResponseRecorder, models.ImportReport) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var report models.ImportReport
+	if strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&report); err != nil {
+			t.Fatalf("Ошибка при декодировании отчёта: %v", err)
+		}
+	}
+	return rr, report
+}
+
+func TestImportUsers(t *testing.T) {
+	r, store := setupRouter(t)
+
+	rr, report := importCSV(t, r, "/users/import?map=name:Full+Name&map=age:Years",
+		"\ufeffFull Name,Years,Email\nJohn Doe,25,john@example.com\n\"Doe, Jane\",30,jane@example.com\n")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if report.Rows != 2 || report.Imported != 2 || len(report.Errors) != 0 {
+		t.Errorf("Неверный отчёт: %+v", report)
+	}
+	users, _, _ := store.List(context.Background(), 0, 0, 1, 10, services.Sort{{Field: "name"}}, services.ExcludeDeleted, nil)
+	if len(users) != 2 || users[0].Name != "Doe, Jane" || users[0].Age != 30 {
+		t.Errorf("Неверные пользователи: %+v", users)
+	}
+}
+
+func TestImportUsersReportsLines(t *testing.T) {
+	body := "name,age\n" +
+		"John Doe,25\n" +
+		",30\n" +
+		"Jane Doe,abc\n" +
+		"Old Timer,-1\n" +
+		"John Doe,40\n" +
+		"Kid,5\n"
+	want := []models.ImportError{
+		{Line: 3, Column: "name", Message: "is required"},
+		{Line: 4, Column: "age", Message: "must be a whole number"},
+		{Line: 5, Column: "age", Message: "must be at least 0"},
+		{Line: 6, Column: "name", Message: "duplicates the name on line 2"},
+	}
+
+	for _, url := range []string{"/users/import", "/users/import?dry_run=true"} {
+		r, store := setupRouter(t)
+		rr, report := importCSV(t, r, url, body)
+
+		wantStatus := http.StatusUnprocessableEntity
+		if strings.Contains(url, "dry_run") {
+			wantStatus = http.StatusOK
+		}
+		if rr.Code != wantStatus {
+			t.Errorf("%s: неверный код статуса: получили %v, ожидали %v", url, rr.Code, wantStatus)
+		}
+		if report.Rows != 6 || report.Valid != 2 || report.Imported != 0 {
+			t.Errorf("%s: неверные счётчики: %+v", url, report)
+		}
+		if !slices.Equal(report.Errors, want) {
+			t.Errorf("%s: неверные ошибки:\nполучили %+v\nожидали  %+v", url, report.Errors, want)
+		}
+		if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 0 {
+			t.Errorf("%s: ничего не должно быть записано, но сохранено %v пользователей", url, total)
+		}
+	}
+}
+
+func TestImportUsersNonAtomic(t *testing.T) {
+	r, store := setupRouter(t, models.User{Name: "Jane Doe", Age: 30})
+
+	rr, report := importCSV(t, r, "/users/import?atomic=false", "name,age\nJohn Doe,25\nJane Doe,31\nKid,x\n")
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusMultiStatus)
+	}
+	want := []models.ImportError{
+		{Line: 3, Message: `a user with name "Jane Doe" already exists`},
+		{Line: 4, Column: "age", Message: "must be a whole number"},
+	}
+	if report.Imported != 1 || !slices.Equal(report.Errors, want) {
+		t.Errorf("Неверный отчёт: %+v", report)
+	}
+	if _, total, _ := store.List(context.Background(), 0, 0, 1, 10, nil, services.ExcludeDeleted, nil); total != 2 {
+		t.Errorf("Ожидалось 2 пользователя, получили %v", total)
+	}
+}
+
+func TestImportUsersBadRequest(t *testing.T) {
+	r, _ := setupRouter(t)
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"/users/import", ""},
+		{"/users/import", "full name,age\nJohn Doe,25\n"},
+		{"/users/import?map=email:Mail", "name,age\nJohn Doe,25\n"},
+		{"/users/import?map=name", "name,age\nJohn Doe,25\n"},
+	}
+	for _, tt := range tests {
+		if rr, _ := importCSV(t, r, tt.url, tt.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s %q: неверный код статуса: получили %v, ожидали %v", tt.url, tt.body, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	if rr := doRequest(t, r, "POST", "/users/import", []models.User{{Name: "John Doe"}}); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	r, store := setupRouter(t,
+		models.User{Name: "A", Age: 18},
+		models.User{Name: "B", Age: 25},
+		models.User{Name: "C", Age: 31},
+		models.User{Name: "D", Age: 44},
+		models.User{Name: "E", Age: 70},
+	)
+	if err := store.Delete(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doRequest(t, r, "GET", "/users/stats?bucket_width=10", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusOK)
+	}
+	var stats models.UserStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if stats.Count != 4 || stats.MinAge != 18 || stats.MaxAge != 44 || stats.AvgAge != 29.5 || stats.MedianAge != 28 {
+		t.Errorf("Неверная сводка: %+v", stats)
+	}
+	if stats.Percentiles["p25"] != 23.25 || stats.Percentiles["p99"] != 43.61 {
+		t.Errorf("Неверные перцентили: %v", stats.Percentiles)
+	}
+	want := []models.AgeBucket{{From: 10, To: 20, Count: 1}, {From: 20, To: 30, Count: 1}, {From: 30, To: 40, Count: 1}, {From: 40, To: 50, Count: 1}}
+	if !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Неверная гистограмма: %+v", stats.Histogram)
+	}
+
+	rr = doRequest(t, r, "GET", "/users/stats?min_age=30&include_deleted=true&bucket_width=25", nil)
+	stats = models.UserStats{}
+	json.NewDecoder(rr.Body).Decode(&stats)
+	want = []models.AgeBucket{{From: 25, To: 50, Count: 2}, {From: 50, To: 75, Count: 1}}
+	if stats.Count != 3 || !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Неверная статистика с фильтрами: %+v", stats)
+	}
+
+	rr = doRequest(t, r, "GET", "/users/stats?min_age=100", nil)
+	stats = models.UserStats{}
+	json.NewDecoder(rr.Body).Decode(&stats)
+	if stats.Count != 0 || stats.Histogram == nil || len(stats.Histogram) != 0 {
+		t.Errorf("Неверная статистика пустой выборки: %+v", stats)
+	}
+
+	if rr := doRequest(t, r, "GET", "/users/stats?bucket_width=0", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetUserStatsWideSpread(t *testing.T) {
+	r, _ := setupRouter(t, models.User{Name: "A", Age: 3}, models.User{Name: "B", Age: 2_000_000_000})
+
+	rr := doRequest(t, r, "GET", "/users/stats?bucket_width=1", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusOK)
+	}
+	var stats models.UserStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	want := []models.AgeBucket{{From: 3, To: 4, Count: 1}, {From: 2_000_000_000, To: 2_000_000_001, Count: 1}}
+	if !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Гистограмма с пустыми корзинами сверх предела: %d корзин", len(stats.Histogram))
+	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"gormADV/internal/app"
+	"gormADV/internal/config"
 	"gormADV/internal/database"
+	"gormADV/internal/logging"
+	"log/slog"
+	"os"
 )
 
 // @title           GO REST API WITH GORM
@@ -22,7 +25,18 @@ import (
 // @BasePath  /
 
 func main() {
-	fmt.Println("Сервер запущен на порту 8080")
-	database.ConnectDB()
-	app.Run()
+	logging.Setup(os.Stderr, config.AppConfig.LogLevel)
+
+	if err := database.ConnectDB(); err != nil {
+		fatal(err)
+	}
+	if err := app.Run(); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error("Exiting", "error", err)
+	os.Exit(1)
 }
@@ -24,6 +24,152 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/audit": {
+            "get": {
+                "description": "Get the audit trail of all users, newest first, optionally filtered. since and until are RFC 3339 timestamps; since is inclusive and until exclusive.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "audit"
+                ],
+                "summary": "Get audit feed",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "create",
+                            "update",
+                            "delete",
+                            "restore"
+                        ],
+                        "type": "string",
+                        "description": "Action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed actor, as sent in X-Actor",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "request_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Earliest change time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Latest change time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.AuditListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Reports that the process is up and serving HTTP. It does not look at any dependency, so a failing database does not get the service restarted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Pings the database and checks that the schema is up to date, with a timeout, and reports the status of each. Traffic should only be sent while this returns 200.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    },
+                    "503": {
+                        "description": "A dependency is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
         "/users": {
             "get": {
                 "description": "Get a paginated list of users with optional filters for age and sorting in ascending or descending order.",
@@ -61,32 +207,648 @@ const docTemplate = `{
                         "name": "page_size",
                         "in": "query"
                     },
-                    {
-                        "type": "string",
-                        "description": "Sort by name in ascending or descending order",
-                        "name": "sort",
-                        "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    {
+                        "type": "string",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id.",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also list users in the trash",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Relations to return; only profile. Defaults to profile unless fields is given",
+                        "name": "include",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid sort or projection",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new user with profile",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create user",
+                "parameters": [
+                    {
+                        "description": "User to create",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request payload",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User conflicts with an existing record",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/export": {
+            "get": {
+                "description": "Stream every user matching the filters of GET /users, without paging, as CSV, newline-delimited JSON or a JSON array. Rows are read from a single database cursor and flushed to the client as they arrive, so exports of any size use constant memory.\nWith flatten=true the profile's bio and picture URL become profile_bio and profile_picture_url columns of the user; otherwise CSV carries only the user's columns and JSON nests the profile as in GET /users.\nIn CSV, text starting with =, +, -, @, a tab or a carriage return is prefixed with an apostrophe so that spreadsheets do not run it as a formula.\nIf the export fails after it has started, the connection is closed before the document is complete.\nThe whole download must finish within the route timeout (10 minutes by default, unless ROUTE_TIMEOUTS sets another for GET /users/export); slower exports are cut off.",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson",
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Export users",
+                "parameters": [
+                    {
+                        "enum": [
+                            "csv",
+                            "ndjson",
+                            "json"
+                        ],
+                        "type": "string",
+                        "default": "csv",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Inline the profile columns",
+                        "name": "flatten",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id.",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.User"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid format or sort",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/stats": {
+            "get": {
+                "description": "Get the number of users, their minimum, maximum, mean and median age, age percentiles, an age histogram and the share of users who filled in their profile. Accepts the same filters as GET /users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "User statistics",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also count users in the trash",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Width of the histogram buckets in years (default 10)",
+                        "name": "bucket_width",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserStats"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid bucket width",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/trash": {
+            "get": {
+                "description": "Get a paginated list of soft-deleted users. Accepts the same filters, sorting and pagination as GET /users. Each user carries the profile that was deleted with it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List deleted users",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Minimum Age",
+                        "name": "min_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Age",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id.",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Relations to return; only profile. Defaults to profile unless fields is given",
+                        "name": "include",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid sort or projection",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}": {
+            "get": {
+                "description": "Get a user and its profile by ID. The response carries a strong ETag derived from the data and the requested fields and relations, and a Last-Modified date; sending them back in If-None-Match or If-Modified-Since returns 304 Not Modified while the user is unchanged. Only the ETag of the whole user is accepted in If-Match by writes.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last-Modified from a previous response",
+                        "name": "If-Modified-Since",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Relations to return; only profile. Defaults to profile unless fields is given",
+                        "name": "include",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID or projection",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update user details by ID. The write is conditional on the version the client last read, given as the ETag in If-Match or as the version field; if the user changed since, the current user is returned with 412.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being updated",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Updated user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request payload",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User conflicts with an existing record",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "412": {
+                        "description": "User was modified; current user",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "422": {
+                        "description": "Data violates a constraint",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match header or version field required",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Move a user and its profile to the trash by ID. They can be brought back with POST /users/{id}/restore.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid user ID",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
                         "schema": {
-                            "$ref": "#/definitions/models.UserListResponse"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
             },
-            "post": {
-                "description": "Create a new user with profile",
+            "patch": {
+                "description": "Update only the fields present in the patch, e.g. the profile bio without touching profile_picture_url. The body is either a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json) or a JSON Patch (RFC 6902, Content-Type application/json-patch+json). The patched user is validated again and written in a single transaction.\nThe write is conditional on the version the client last read, given as the ETag in If-Match, as a version member of a merge patch or as a test operation on /version; if the user changed since, the current user is returned with 412.",
                 "consumes": [
-                    "application/json"
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
                 ],
                 "produces": [
                     "application/json"
@@ -94,53 +856,117 @@ const docTemplate = `{
                 "tags": [
                     "users"
                 ],
-                "summary": "Create user",
+                "summary": "Patch user",
                 "parameters": [
                     {
-                        "description": "User to create",
-                        "name": "user",
+                        "type": "integer",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being patched",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Merge patch object or array of JSON Patch operations",
+                        "name": "patch",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "type": "object"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/models.User"
                         }
                     },
                     "400": {
-                        "description": "Invalid request payload",
+                        "description": "Malformed patch document",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "Test operation failed or user conflicts with an existing record",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "412": {
+                        "description": "User was modified; current user",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported patch media type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "422": {
+                        "description": "Patch cannot be applied or result is invalid",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match header or version required",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
             }
         },
-        "/users/{id}": {
-            "put": {
-                "description": "Update user details by ID",
-                "consumes": [
-                    "application/json"
-                ],
+        "/users/{id}/history": {
+            "get": {
+                "description": "Get the audit trail of a user, newest first: every create, update, delete and restore with the user and its profile before and after the change, who made it and in which request. Deleted users keep their history.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "audit"
                 ],
-                "summary": "Update user",
+                "summary": "Get user history",
                 "parameters": [
                     {
                         "type": "integer",
@@ -150,54 +976,62 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "Updated user",
-                        "name": "user",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.User"
-                        }
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size",
+                        "name": "page_size",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/models.AuditListResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid request payload",
+                        "description": "Invalid user ID",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
-                    "404": {
-                        "description": "User not found",
+                    "429": {
+                        "description": "Too many requests",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete user by ID",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/users/{id}/restore": {
+            "post": {
+                "description": "Bring a user back from the trash by ID, together with the profile deleted along with it.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "users"
                 ],
-                "summary": "Delete user",
+                "summary": "Restore user",
                 "parameters": [
                     {
                         "type": "integer",
@@ -205,31 +1039,55 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Claimed author of the change, recorded in the audit trail. Not authenticated.",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.User"
                         }
                     },
                     "400": {
                         "description": "Invalid user ID",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "404": {
                         "description": "User not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "409": {
+                        "description": "User is not deleted",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Details"
+                        }
+                    },
+                    "504": {
+                        "description": "Request timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Details"
                         }
                     }
                 }
@@ -237,6 +1095,117 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/health.Result"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                }
+            }
+        },
+        "health.Result": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Error says why the check failed.",
+                    "type": "string",
+                    "example": "context deadline exceeded"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                }
+            }
+        },
+        "models.AgeBucket": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "The number of users in the bucket.\nexample: 12",
+                    "type": "integer"
+                },
+                "from": {
+                    "description": "The lowest age in the bucket.\nexample: 20",
+                    "type": "integer"
+                },
+                "to": {
+                    "description": "The lowest age of the next bucket.\nexample: 30",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.AuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "description": "One of create, update, delete or restore.\nexample: update",
+                    "type": "string"
+                },
+                "actor": {
+                    "description": "The claimed actor: who the client said made the change, from the\nX-Actor request header. The header is not authenticated.\nexample: alice",
+                    "type": "string"
+                },
+                "after": {
+                    "description": "The user and profile after the change. Not set for delete.",
+                    "type": "object"
+                },
+                "before": {
+                    "description": "The user and profile before the change. Not set for create; for\nrestore, the deleted user without its profile.",
+                    "type": "object"
+                },
+                "created_at": {
+                    "description": "When the change was made.\nexample: 2024-01-02T15:04:05Z",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "The entry's ID.\nexample: 1",
+                    "type": "integer"
+                },
+                "request_id": {
+                    "description": "The ID of the request that made the change.\nexample: 4bf92f3577b34da6a3ce929d0e0e4736",
+                    "type": "string"
+                },
+                "user_id": {
+                    "description": "The ID of the changed user.\nexample: 1",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.AuditListResponse": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "description": "The list of entries.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AuditEntry"
+                    }
+                },
+                "page": {
+                    "description": "The current page number.\nexample: 1",
+                    "type": "integer"
+                },
+                "page_size": {
+                    "description": "The size of each page.\nexample: 10",
+                    "type": "integer"
+                },
+                "total_items": {
+                    "description": "The total number of matching entries.\nexample: 100",
+                    "type": "integer"
+                },
+                "total_pages": {
+                    "description": "The total number of pages.\nexample: 10",
+                    "type": "integer"
+                }
+            }
+        },
         "models.Profile": {
             "type": "object",
             "properties": {
@@ -268,6 +1237,7 @@ const docTemplate = `{
                 "age": {
                     "description": "The user's age.\nexample: 30",
                     "type": "integer",
+                    "maximum": 150,
                     "minimum": 0
                 },
                 "id": {
@@ -285,6 +1255,10 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.Profile"
                         }
                     ]
+                },
+                "version": {
+                    "description": "The user's version, incremented on every change to the user or its\nprofile. Send it back in If-Match or in this field to update the user.\nexample: 1",
+                    "type": "integer"
                 }
             }
         },
@@ -315,6 +1289,119 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "models.UserStats": {
+            "type": "object",
+            "properties": {
+                "avg_age": {
+                    "description": "The mean age.\nexample: 37.4",
+                    "type": "number"
+                },
+                "bucket_width": {
+                    "description": "The width of the histogram buckets, in years.\nexample: 10",
+                    "type": "integer"
+                },
+                "count": {
+                    "description": "The number of users.\nexample: 100",
+                    "type": "integer"
+                },
+                "histogram": {
+                    "description": "The number of users per age bracket, from the youngest to the oldest\nuser, including empty brackets in between unless there would be more\nthan 1000 brackets.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AgeBucket"
+                    }
+                },
+                "max_age": {
+                    "description": "The oldest age.\nexample: 72",
+                    "type": "integer"
+                },
+                "median_age": {
+                    "description": "The median age.\nexample: 35",
+                    "type": "number"
+                },
+                "min_age": {
+                    "description": "The youngest age.\nexample: 18",
+                    "type": "integer"
+                },
+                "percentiles": {
+                    "description": "Age percentiles keyed p25, p50, p75, p90, p95 and p99, interpolated\nbetween neighbouring ages.\nexample: {\"p25\":27,\"p50\":35,\"p75\":46.5,\"p90\":58,\"p95\":63.1,\"p99\":70.2}",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
+                },
+                "profile_share": {
+                    "description": "WithProfile as a fraction of Count.\nexample: 0.64",
+                    "type": "number"
+                },
+                "with_profile": {
+                    "description": "The number of users whose profile has a bio or a picture.\nexample: 64",
+                    "type": "integer"
+                }
+            }
+        },
+        "problem.Details": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "description": "Detail explains this occurrence of the problem.",
+                    "type": "string",
+                    "example": "Invalid user ID"
+                },
+                "errors": {
+                    "description": "Errors lists the broken rules of a validation problem.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/problem.FieldError"
+                    }
+                },
+                "instance": {
+                    "description": "Instance is the request URI the problem occurred on.",
+                    "type": "string",
+                    "example": "/users/abc"
+                },
+                "request_id": {
+                    "description": "RequestID is the X-Request-ID of the request, to find it in the logs.",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "status": {
+                    "description": "Status is the HTTP status code of the response.",
+                    "type": "integer",
+                    "example": 400
+                },
+                "title": {
+                    "description": "Title is a short summary of the kind of problem.",
+                    "type": "string",
+                    "example": "Bad Request"
+                },
+                "type": {
+                    "description": "Type is a URI reference that identifies the kind of problem.",
+                    "type": "string",
+                    "example": "about:blank"
+                }
+            }
+        },
+        "problem.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "Field is the JSON path of the field, e.g. profile.bio.",
+                    "type": "string",
+                    "example": "name"
+                },
+                "message": {
+                    "description": "Message describes the broken rule in words.",
+                    "type": "string",
+                    "example": "is required"
+                },
+                "rule": {
+                    "description": "Rule is the name of the broken rule.",
+                    "type": "string",
+                    "example": "required"
+                }
+            }
         }
     }
 }`
@@ -1,29 +1,96 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"gormADV/internal/config"
 	"gormADV/internal/database"
+	"gormADV/internal/health"
+	"gormADV/internal/metrics"
 	"gormADV/internal/models"
+	"gormADV/internal/ratelimit"
+	"gormADV/internal/tracing"
 	"gormADV/internal/transport"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "gormADV/docs"
 )
 
-func Run() {
-	err := database.DB.AutoMigrate(&models.User{}, &models.Profile{})
+// traceFlushTimeout bounds the export of the last spans on shutdown.
+const traceFlushTimeout = 5 * time.Second
+
+// evictInterval is how often rate limit buckets that have refilled are
+// dropped.
+const evictInterval = time.Minute
+
+// Run serves the API until the process is asked to stop, then shuts down
+// gracefully and closes the database. The health endpoints answer at once;
+// the API only once the models have been migrated.
+func Run() error {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: config.AppConfig.TracesExporter,
+		File:     config.AppConfig.TracesFile,
+	})
 	if err != nil {
-		log.Fatalf("Failed to auto-migrate models: %v", err)
+		return fmt.Errorf("failed to set up tracing: %w", err)
 	}
-	log.Println("Auto migration completed.")
 
-	r := mux.NewRouter()
+	var migrated health.Flag
+	checker := health.NewChecker(config.AppConfig.ReadinessTimeout)
+	checker.Add("database", database.Ping)
+	checker.Add("migrations", migrated.Check)
 
-	transport.RegisterRoutes(r)
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+	if err := metrics.RegisterDB(sqlDB, config.AppConfig.DBName); err != nil {
+		return fmt.Errorf("failed to register database metrics: %w", err)
+	}
 
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware("/healthz", "/readyz", "/metrics"))
+	transport.RegisterHealthRoutes(r, transport.NewHealthHandler(checker))
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	limiter := ratelimit.NewLimiter(config.AppConfig.RateLimit, config.AppConfig.RouteRateLimits, config.AppConfig.RateLimitMaxBuckets)
+	transport.RegisterRoutes(r,
+		transport.RequireStarted(&migrated),
+		transport.RateLimit(limiter, transport.ClientKey{
+			By:                config.AppConfig.RateLimitKey,
+			TrustForwardedFor: config.AppConfig.TrustForwardedFor,
+		}),
+	)
+
+	srv := newServer(&http.Server{
+		Addr:              ":8080",
+		Handler:           r,
+		ReadHeaderTimeout: config.AppConfig.ReadHeaderTimeout,
+		ReadTimeout:       config.AppConfig.ReadTimeout,
+		WriteTimeout:      config.AppConfig.WriteTimeout,
+		IdleTimeout:       config.AppConfig.IdleTimeout,
+	}, config.AppConfig.ShutdownTimeout)
+	srv.goWorker(func(ctx context.Context) {
+		limiter.Run(ctx, evictInterval)
+	})
+	srv.onClose(database.Close)
+	srv.onClose(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
+	return srv.run(func(ctx context.Context) error {
+		err := database.DB.WithContext(ctx).AutoMigrate(&models.User{}, &models.Profile{}, &models.AuditEntry{})
+		if err != nil {
+			return fmt.Errorf("failed to auto-migrate models: %w", err)
+		}
+		slog.InfoContext(ctx, "Auto migration completed")
+		migrated.Set()
+		return nil
+	})
 }
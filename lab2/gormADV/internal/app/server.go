@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// server runs an http.Server along with the application's background
+// workers until the process is asked to stop, then takes them down in order:
+// requests first, then workers, then the resources they use.
+type server struct {
+	http            *http.Server
+	shutdownTimeout time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	closers []func() error
+}
+
+func newServer(srv *http.Server, shutdownTimeout time.Duration) *server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{http: srv, shutdownTimeout: shutdownTimeout, ctx: ctx, cancel: cancel}
+}
+
+// goWorker runs fn in the background. Its context is cancelled once the
+// server has stopped taking requests, and shutdown waits for fn to return.
+func (s *server) goWorker(fn func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		fn(s.ctx)
+	}()
+}
+
+// onClose registers fn to release a resource after the workers have
+// stopped. Closers run in the order they were registered.
+func (s *server) onClose(fn func() error) {
+	s.closers = append(s.closers, fn)
+}
+
+// run serves until SIGINT or SIGTERM arrives or the listener fails, and then
+// shuts down. A second signal kills the process without waiting. Once the
+// listener is up, start runs as a worker to prepare the service; if it fails
+// the server shuts down too.
+func (s *server) run(start func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- s.http.ListenAndServe()
+	}()
+	slog.Info("Listening", "addr", s.http.Addr)
+
+	startErr := make(chan error, 1)
+	s.goWorker(func(ctx context.Context) {
+		startErr <- start(ctx)
+	})
+
+	var err error
+	for err == nil && ctx.Err() == nil {
+		select {
+		case err = <-listenErr:
+			err = fmt.Errorf("failed to serve: %w", err)
+		case err = <-startErr:
+			// A nil channel never receives, so a successful start is
+			// not seen again.
+			startErr = nil
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+		}
+	}
+	stop()
+
+	return errors.Join(err, s.shutdown())
+}
+
+// shutdown drains in-flight requests, stops the workers and runs the
+// closers. Requests and workers share shutdownTimeout; requests still
+// running after it are cut off.
+func (s *server) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	var err error
+	if drainErr := s.http.Shutdown(ctx); drainErr != nil {
+		err = fmt.Errorf("failed to drain requests: %w", drainErr)
+		s.http.Close()
+	}
+
+	s.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = errors.Join(err, errors.New("background workers did not stop in time"))
+	}
+
+	for _, closer := range s.closers {
+		if closeErr := closer(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close: %w", closeErr))
+		}
+	}
+	if err == nil {
+		slog.Info("Server stopped")
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+// Package audit carries the author of a change through the request context
+// so the stores can record it in the user audit trail.
+package audit
+
+import "context"
+
+// Actions recorded in the audit trail.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// Info identifies who made a change and in which request.
+type Info struct {
+	// Actor is who the client claims to be. Nothing checks it, so it must
+	// not be used for access decisions.
+	Actor     string
+	RequestID string
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the Info stored in ctx, or the zero Info if there is
+// none.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
+
+// ValidAction reports whether action is one of the recorded actions.
+func ValidAction(action string) bool {
+	switch action {
+	case ActionCreate, ActionUpdate, ActionDelete, ActionRestore:
+		return true
+	}
+	return false
+}
@@ -3,8 +3,16 @@ package config
 import (
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
+	"gormADV/internal/ratelimit"
 	"log"
+	"log/slog"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,10 +21,64 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	DBPort     string
+
+	// RequestTimeout bounds how long a request may spend in the database.
+	RequestTimeout time.Duration
+	// RouteTimeouts overrides RequestTimeout per route, keyed by
+	// "METHOD /path/template", e.g. "GET /users".
+	RouteTimeouts map[string]time.Duration
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound
+	// the phases of an HTTP connection, as in http.Server.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration
+	// ReadinessTimeout bounds the checks behind /readyz.
+	ReadinessTimeout time.Duration
+
+	// LogLevel is the least severe level that is logged.
+	LogLevel slog.Level
+
+	// TracesExporter is where spans go: none, otlp, stdout or file. The
+	// OTLP endpoint is set by the standard OTEL_EXPORTER_OTLP_* variables.
+	TracesExporter string
+	// TracesFile is the file the file exporter appends spans to.
+	TracesFile string
+
+	// RateLimit is how often each client may call a route, unless
+	// RouteRateLimits, keyed like RouteTimeouts, says otherwise.
+	RateLimit       ratelimit.Limit
+	RouteRateLimits map[string]ratelimit.Limit
+	// RateLimitKey tells clients apart: ip, api_key or jwt_sub.
+	RateLimitKey string
+	// RateLimitMaxBuckets caps the rate limit buckets kept in memory, one
+	// per route and client; past it the least recently used are dropped.
+	RateLimitMaxBuckets int
+	// TrustForwardedFor takes client IPs from the X-Forwarded-For header
+	// set by a proxy in front of the service.
+	TrustForwardedFor bool
 }
 
 var AppConfig *Config
-var Validate = validator.New()
+var Validate = newValidator()
+
+// newValidator returns a validator that names fields by their JSON names,
+// as clients know them.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
 
 func init() {
 	if err := godotenv.Load("../../../.env"); err != nil {
@@ -29,5 +91,166 @@ func init() {
 		DBPassword: os.Getenv("DB_PASSWORD"),
 		DBName:     os.Getenv("DB_NAME_GORM"),
 		DBPort:     os.Getenv("DB_PORT"),
+
+		RequestTimeout: durationEnv("REQUEST_TIMEOUT", 5*time.Second),
+		RouteTimeouts:  routeTimeoutsEnv("ROUTE_TIMEOUTS", defaultRouteTimeouts),
+
+		ReadHeaderTimeout: durationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       durationEnv("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      durationEnv("WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       durationEnv("IDLE_TIMEOUT", 120*time.Second),
+		ShutdownTimeout:   durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
+		ReadinessTimeout:  durationEnv("READINESS_TIMEOUT", 2*time.Second),
+
+		LogLevel: levelEnv("LOG_LEVEL", slog.LevelInfo),
+
+		TracesExporter: stringEnv("TRACES_EXPORTER", "none"),
+		TracesFile:     stringEnv("TRACES_FILE", "traces.json"),
+
+		RateLimit:           limitEnv("RATE_LIMIT", "600/1m"),
+		RouteRateLimits:     routeLimitsEnv("ROUTE_RATE_LIMITS"),
+		RateLimitKey:        oneOfEnv("RATE_LIMIT_KEY", "ip", "api_key", "jwt_sub"),
+		RateLimitMaxBuckets: intEnv("RATE_LIMIT_MAX_BUCKETS", 100000),
+		TrustForwardedFor:   boolEnv("TRUST_FORWARDED_FOR", false),
+	}
+}
+
+// Timeout returns the request deadline of a route. Zero means no deadline.
+func (c *Config) Timeout(method, path string) time.Duration {
+	if d, ok := c.RouteTimeouts[method+" "+path]; ok {
+		return d
+	}
+	return c.RequestTimeout
+}
+
+func stringEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// oneOfEnv returns the value of key, which must be one of values. The first
+// is the default.
+func oneOfEnv(key string, values ...string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return values[0]
+	}
+	if !slices.Contains(values, value) {
+		log.Fatalf("Invalid %s %q: expected one of %s", key, value, strings.Join(values, ", "))
+	}
+	return value
+}
+
+func intEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid %s %q: expected a non-negative number", key, value)
+	}
+	return n
+}
+
+func boolEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return b
+}
+
+// limitEnv parses a rate limit such as 10/1m, or none.
+func limitEnv(key, fallback string) ratelimit.Limit {
+	value := os.Getenv(key)
+	if value == "" {
+		value = fallback
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return limit
+}
+
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return d
+}
+
+// levelEnv parses a log level such as debug, info, warn or error.
+func levelEnv(key string, fallback slog.Level) slog.Level {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return level
+}
+
+// defaultRouteTimeouts gives the streaming routes, whose uploads and
+// downloads outlast REQUEST_TIMEOUT, a deadline of their own.
+var defaultRouteTimeouts = map[string]time.Duration{
+	"GET /users/export": 10 * time.Minute,
+}
+
+// routeTimeoutsEnv parses a comma separated list of "METHOD /path=duration"
+// entries, e.g. "GET /users=2s,POST /users=30s", over defaults.
+func routeTimeoutsEnv(key string, defaults map[string]time.Duration) map[string]time.Duration {
+	timeouts := maps.Clone(defaults)
+	value := os.Getenv(key)
+	if value == "" {
+		return timeouts
+	}
+	for _, entry := range strings.Split(value, ",") {
+		route, duration, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			log.Fatalf("Invalid %s entry %q: expected METHOD /path=duration", key, entry)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", key, entry, err)
+		}
+		timeouts[strings.Join(strings.Fields(route), " ")] = d
+	}
+	return timeouts
+}
+
+// routeLimitsEnv parses a comma separated list of "METHOD /path=limit"
+// entries, e.g. "POST /users=10/1m,GET /users=none".
+func routeLimitsEnv(key string) map[string]ratelimit.Limit {
+	limits := map[string]ratelimit.Limit{}
+	value := os.Getenv(key)
+	if value == "" {
+		return limits
+	}
+	for _, entry := range strings.Split(value, ",") {
+		route, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			log.Fatalf("Invalid %s entry %q: expected METHOD /path=limit", key, entry)
+		}
+		l, err := ratelimit.ParseLimit(limit)
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", key, entry, err)
+		}
+		limits[strings.Join(strings.Fields(route), " ")] = l
 	}
+	return limits
 }
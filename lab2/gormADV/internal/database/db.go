@@ -1,11 +1,14 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gormADV/internal/config"
-	"log"
+	"gormADV/internal/logging"
+	"gormADV/internal/tracing"
+	"log/slog"
 	"time"
 )
 
@@ -19,11 +22,15 @@ func ConnectDB() error {
 		config.AppConfig.DBName,
 		config.AppConfig.DBPort,
 	)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logging.GormLogger{SlowThreshold: 200 * time.Millisecond}})
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		return fmt.Errorf("failed to register tracing: %w", err)
+	}
+
 	sqlDB, err := db.DB()
 	if err != nil {
 		return fmt.Errorf("failed to get database: %w", err)
@@ -34,6 +41,28 @@ func ConnectDB() error {
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
 	DB = db
-	log.Println("Successfully connected to the database.")
+	slog.Info("Connected to the database", "host", config.AppConfig.DBHost, "name", config.AppConfig.DBName)
 	return nil
 }
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the connection pool. Queries in progress are allowed to
+// finish.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+	return sqlDB.Close()
+}
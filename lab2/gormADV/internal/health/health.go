@@ -0,0 +1,102 @@
+// Package health reports whether the service is ready to take traffic.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check statuses.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Check reports whether a dependency is usable. It should give up once ctx
+// is done.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status string `json:"status" example:"ok"`
+	// Error says why the check failed.
+	Error string `json:"error,omitempty" example:"context deadline exceeded"`
+}
+
+// Report is the overall status of the service and, for readiness, the
+// result of every check.
+type Report struct {
+	Status string            `json:"status" example:"ok"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Checker runs the readiness checks of the service.
+type Checker struct {
+	timeout time.Duration
+	checks  map[string]Check
+}
+
+// NewChecker returns a Checker that fails checks taking longer than timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Add registers check under name.
+func (c *Checker) Add(name string, check Check) {
+	c.checks[name] = check
+}
+
+// Run runs every check concurrently. The service is ready if all passed.
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := Result{Status: StatusOK}
+			if err := check(ctx); err != nil {
+				result = Result{Status: StatusUnavailable, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// Flag tracks a one-off startup task, such as applying migrations.
+type Flag struct {
+	done atomic.Bool
+}
+
+// Set marks the task as completed.
+func (f *Flag) Set() {
+	f.done.Store(true)
+}
+
+// IsSet reports whether the task has completed.
+func (f *Flag) IsSet() bool {
+	return f.done.Load()
+}
+
+// Check is a Check that fails until the task has completed.
+func (f *Flag) Check(ctx context.Context) error {
+	if !f.IsSet() {
+		return errors.New("not completed yet")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"gormADV/internal/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape returns the value of every series served by metrics.Handler.
+func scrape(t *testing.T) map[string]float64 {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	series := map[string]float64{}
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("Неверное значение метрики %q: %v", line, err)
+		}
+		series[name] = v
+	}
+	return series
+}
+
+func TestInstrument(t *testing.T) {
+	inFlight := `http_requests_in_flight{method="GET",route="/things/{id}"}`
+	var during float64
+	handler := metrics.Instrument("GET", "/things/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		during = scrape(t)[inFlight]
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	before := scrape(t)
+	for _, url := range []string{"/things/1", "/things/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	after := scrape(t)
+
+	for series, want := range map[string]float64{
+		`http_requests_total{code="404",method="get",route="/things/{id}"}`:                 2,
+		`http_request_duration_seconds_count{code="404",method="get",route="/things/{id}"}`: 2,
+	} {
+		if got := after[series] - before[series]; got != want {
+			t.Errorf("%s: получили прирост %v, ожидали %v", series, got, want)
+		}
+	}
+	if during != 1 || after[inFlight] != 0 {
+		t.Errorf("%s: во время запроса %v, после %v; ожидали 1 и 0", inFlight, during, after[inFlight])
+	}
+}
+
+func TestRegisterDB(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+	if err := metrics.RegisterDB(db, "metrics_test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := metrics.RegisterDB(db, "metrics_test"); err == nil {
+		t.Error("Повторная регистрация пула с тем же именем должна завершаться ошибкой")
+	}
+
+	series := scrape(t)
+	for name, want := range map[string]float64{
+		`go_sql_max_open_connections{db_name="metrics_test"}`: 7,
+		`go_sql_in_use_connections{db_name="metrics_test"}`:   0,
+	} {
+		if got, ok := series[name]; !ok || got != want {
+			t.Errorf("%s: получили %v, ожидали %v", name, got, want)
+		}
+	}
+}
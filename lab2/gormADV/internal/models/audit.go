@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// JSON is a JSON document stored in a jsonb column. An empty JSON is stored
+// as NULL.
+type JSON json.RawMessage
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*j = nil
+		return nil
+	}
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSON) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSON(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", src)
+	}
+	return nil
+}
+
+// AuditEntry records one change to a user and its profile.
+// swagger:model
+type AuditEntry struct {
+	// The entry's ID.
+	// example: 1
+	ID uint `json:"id" gorm:"primaryKey"`
+	// The ID of the changed user.
+	// example: 1
+	UserID uint `json:"user_id" gorm:"not null;index"`
+	// One of create, update, delete or restore.
+	// example: update
+	Action string `json:"action" gorm:"size:16;not null"`
+	// The user and profile before the change. Not set for create; for
+	// restore, the deleted user without its profile.
+	Before JSON `json:"before,omitempty" gorm:"type:jsonb" swaggertype:"object"`
+	// The user and profile after the change. Not set for delete.
+	After JSON `json:"after,omitempty" gorm:"type:jsonb" swaggertype:"object"`
+	// The claimed actor: who the client said made the change, from the
+	// X-Actor request header. The header is not authenticated.
+	// example: alice
+	Actor string `json:"actor" gorm:"not null"`
+	// The ID of the request that made the change.
+	// example: 4bf92f3577b34da6a3ce929d0e0e4736
+	RequestID string `json:"request_id" gorm:"not null"`
+	// When the change was made.
+	// example: 2024-01-02T15:04:05Z
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// TableName keeps the audit trail in user_audit, next to users.
+func (AuditEntry) TableName() string {
+	return "user_audit"
+}
+
+// AuditListResponse represents a paginated list of audit entries, newest
+// first.
+// swagger:model
+type AuditListResponse struct {
+	// The list of entries.
+	Entries []AuditEntry `json:"entries"`
+	// The total number of matching entries.
+	// example: 100
+	TotalItems int `json:"total_items"`
+	// The current page number.
+	// example: 1
+	Page int `json:"page"`
+	// The size of each page.
+	// example: 10
+	PageSize int `json:"page_size"`
+	// The total number of pages.
+	// example: 10
+	TotalPages int `json:"total_pages"`
+}
@@ -11,7 +11,11 @@ type User struct {
 	Name string `json:"name" validate:"required"`
 	// The user's age.
 	// example: 30
-	Age int `json:"age" validate:"required,gte=0"`
+	Age int `json:"age" validate:"required,gte=0,lte=150"`
+	// The user's version, incremented on every change to the user or its
+	// profile. Send it back in If-Match or in this field to update the user.
+	// example: 1
+	Version int `json:"version" gorm:"not null;default:1"`
 	// The user's profile.
 	Profile *Profile `json:"profile"`
 }
@@ -0,0 +1,110 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"errors"
+	"gormADV/internal/patch"
+	"reflect"
+	"testing"
+)
+
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("Не удалось разобрать результат %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("Не удалось разобрать ожидаемое значение %s: %v", want, err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("Неверный результат: получили %s, ожидали %s", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	doc := `{"name":"John","age":30,"profile":{"bio":"Dev","url":"http://a"}}`
+	tests := []struct {
+		patch string
+		want  string
+	}{
+		{`{"age":31}`, `{"name":"John","age":31,"profile":{"bio":"Dev","url":"http://a"}}`},
+		{`{"profile":{"bio":"Ops"}}`, `{"name":"John","age":30,"profile":{"bio":"Ops","url":"http://a"}}`},
+		{`{"profile":null}`, `{"name":"John","age":30}`},
+		{`{"profile":{"url":null}}`, `{"name":"John","age":30,"profile":{"bio":"Dev"}}`},
+		{`{}`, doc},
+	}
+
+	for _, tt := range tests {
+		got, err := patch.Merge([]byte(doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("Merge(%s) завершился с ошибкой: %v", tt.patch, err)
+		}
+		assertJSON(t, got, tt.want)
+	}
+}
+
+func TestMergeMalformed(t *testing.T) {
+	_, err := patch.Merge([]byte(`{}`), []byte(`{"age":`))
+	if !errors.Is(err, patch.ErrMalformed) {
+		t.Errorf("Ожидалась ошибка ErrMalformed, получили %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := `{"name":"John","age":30,"tags":["a","b"]}`
+	tests := []struct {
+		patch string
+		want  string
+	}{
+		{`[{"op":"replace","path":"/age","value":31}]`, `{"name":"John","age":31,"tags":["a","b"]}`},
+		{`[{"op":"add","path":"/tags/1","value":"x"}]`, `{"name":"John","age":30,"tags":["a","x","b"]}`},
+		{`[{"op":"add","path":"/tags/-","value":"c"}]`, `{"name":"John","age":30,"tags":["a","b","c"]}`},
+		{`[{"op":"remove","path":"/tags/0"}]`, `{"name":"John","age":30,"tags":["b"]}`},
+		{`[{"op":"move","from":"/name","path":"/nick"}]`, `{"nick":"John","age":30,"tags":["a","b"]}`},
+		{`[{"op":"copy","from":"/tags","path":"/labels"}]`, `{"name":"John","age":30,"tags":["a","b"],"labels":["a","b"]}`},
+		{`[{"op":"test","path":"/age","value":30},{"op":"replace","path":"/age","value":40}]`, `{"name":"John","age":40,"tags":["a","b"]}`},
+	}
+
+	for _, tt := range tests {
+		got, err := patch.Apply([]byte(doc), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("Apply(%s) завершился с ошибкой: %v", tt.patch, err)
+		}
+		assertJSON(t, got, tt.want)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	doc := `{"name":"John","age":30}`
+	tests := []struct {
+		patch string
+		want  error
+	}{
+		{`{"op":"replace"}`, patch.ErrMalformed},
+		{`[{"op":"frobnicate","path":"/age"}]`, patch.ErrMalformed},
+		{`[{"op":"add","path":"/age"}]`, patch.ErrMalformed},
+		{`[{"op":"replace","path":"/missing","value":1}]`, patch.ErrCannotApply},
+		{`[{"op":"remove","path":"/name/first"}]`, patch.ErrCannotApply},
+		{`[{"op":"test","path":"/age","value":31}]`, patch.ErrTestFailed},
+	}
+
+	for _, tt := range tests {
+		_, err := patch.Apply([]byte(doc), []byte(tt.patch))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Apply(%s): получили ошибку %v, ожидали %v", tt.patch, err, tt.want)
+		}
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	if _, err := patch.ForContentType("application/merge-patch+json; charset=utf-8"); err != nil {
+		t.Errorf("Merge patch не распознан: %v", err)
+	}
+	if _, err := patch.ForContentType(patch.JSONPatchType); err != nil {
+		t.Errorf("JSON patch не распознан: %v", err)
+	}
+	if _, err := patch.ForContentType("application/json"); !errors.Is(err, patch.ErrUnsupportedType) {
+		t.Errorf("Ожидалась ошибка ErrUnsupportedType, получили %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"gormADV/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ratelimit.Limit
+		wantErr bool
+	}{
+		{in: "10/1m", want: ratelimit.Limit{Requests: 10, Period: time.Minute}},
+		{in: " 5/30s ", want: ratelimit.Limit{Requests: 5, Period: 30 * time.Second}},
+		{in: "none"},
+		{in: ""},
+		{in: "10", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "10/-1s", wantErr: true},
+		{in: "ten/1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ratelimit.ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v; ожидали %v, ошибка %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLimiterRefillsAndEvicts(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Requests: 2, Period: 10 * time.Second}, nil, 0)
+	start := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d := limiter.Allow(start, "POST /users", "ip:1.2.3.4"); !d.Allowed {
+			t.Fatalf("Запрос %d отклонён до исчерпания лимита", i+1)
+		}
+	}
+	d := limiter.Allow(start, "POST /users", "ip:1.2.3.4")
+	if d.Allowed || d.Remaining != 0 || d.RetryAfter != 5*time.Second || d.Reset != 10*time.Second {
+		t.Errorf("Неверное решение при исчерпанном лимите: %+v", d)
+	}
+	if d := limiter.Allow(start, "POST /users", "ip:5.6.7.8"); !d.Allowed {
+		t.Error("Другой клиент не должен делить лимит с первым")
+	}
+
+	// One token is back after half the period.
+	if d := limiter.Allow(start.Add(5*time.Second), "POST /users", "ip:1.2.3.4"); !d.Allowed {
+		t.Errorf("Запрос не разрешён после пополнения: %+v", d)
+	}
+
+	// The second client's bucket has refilled by now, the first's has not.
+	if n := limiter.Evict(start.Add(5 * time.Second)); n != 1 || limiter.Len() != 1 {
+		t.Errorf("Вытеснены не те корзины: %d, осталось %d", n, limiter.Len())
+	}
+	if n := limiter.Evict(start.Add(20 * time.Second)); n != 1 || limiter.Len() != 0 {
+		t.Errorf("Полные корзины не вытеснены: %d, осталось %d", n, limiter.Len())
+	}
+}
+
+func TestLimiterMaxBuckets(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Requests: 1, Period: time.Minute}, nil, 2)
+	now := time.Now()
+
+	limiter.Allow(now, "POST /users", "key:a")
+	limiter.Allow(now, "POST /users", "key:b")
+	// a is used again, so b is now the least recently used bucket.
+	if d := limiter.Allow(now, "POST /users", "key:a"); d.Allowed {
+		t.Fatalf("Запрос сверх лимита разрешён: %+v", d)
+	}
+	for i := 0; i < 100; i++ {
+		limiter.Allow(now, "POST /users", fmt.Sprintf("key:random-%d", i))
+		if limiter.Len() > 2 {
+			t.Fatalf("Число корзин превысило предел: %d", limiter.Len())
+		}
+	}
+
+	// The random keys pushed out both buckets, so a starts afresh.
+	if d := limiter.Allow(now, "POST /users", "key:a"); !d.Allowed {
+		t.Errorf("Вытесненная корзина не начата заново: %+v", d)
+	}
+	if n := limiter.Evict(now.Add(time.Minute)); n != 2 || limiter.Len() != 0 {
+		t.Errorf("Полные корзины не вытеснены: %d, осталось %d", n, limiter.Len())
+	}
+}
+
+func TestLimiterUnlimitedRoute(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{Requests: 1, Period: time.Minute}, map[string]ratelimit.Limit{
+		"GET /users": {},
+	}, 0)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if d := limiter.Allow(now, "GET /users", "ip:1.2.3.4"); !d.Allowed {
+			t.Fatalf("Запрос к маршруту без лимита отклонён: %+v", d)
+		}
+	}
+	if limiter.Len() != 0 {
+		t.Errorf("Для маршрута без лимита заведена корзина")
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gormADV/internal/audit"
+	"gormADV/internal/database"
+	"gormADV/internal/models"
+	"time"
+)
+
+// AuditFilter narrows an audit listing. Zero fields match everything.
+type AuditFilter struct {
+	UserID    uint
+	Action    string
+	Actor     string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+}
+
+// recordAudit adds an entry to the audit trail through tx, so it commits or
+// rolls back together with the change it describes. The actor and request
+// ID come from the context of tx.
+func recordAudit(tx *gorm.DB, action string, userID uint, before, after *models.User) error {
+	info := audit.FromContext(tx.Statement.Context)
+	entry := models.AuditEntry{
+		UserID:    userID,
+		Action:    action,
+		Actor:     info.Actor,
+		RequestID: info.RequestID,
+	}
+	for _, state := range []struct {
+		user *models.User
+		dst  *models.JSON
+	}{{before, &entry.Before}, {after, &entry.After}} {
+		if state.user == nil {
+			continue
+		}
+		data, err := json.Marshal(state.user)
+		if err != nil {
+			return fmt.Errorf("failed to encode user: %w", err)
+		}
+		*state.dst = data
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEntries returns one page of the audit trail, newest first, and the
+// total number of matching entries.
+func GetAuditEntries(ctx context.Context, filter AuditFilter, page, pageSize int) ([]models.AuditEntry, int, error) {
+	db := database.DB.WithContext(ctx).Model(&models.AuditEntry{})
+
+	if filter.UserID != 0 {
+		db = db.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		db = db.Where("actor = ?", filter.Actor)
+	}
+	if filter.RequestID != "" {
+		db = db.Where("request_id = ?", filter.RequestID)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		db = db.Where("created_at < ?", filter.Until)
+	}
+
+	var totalCount int64
+	if err := db.Count(&totalCount).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	entries := []models.AuditEntry{}
+	offset := (page - 1) * pageSize
+	if err := db.Order("id DESC").Offset(offset).Limit(pageSize).Find(&entries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get audit entries: %w", err)
+	}
+	return entries, int(totalCount), nil
+}
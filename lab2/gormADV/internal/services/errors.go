@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Domain errors returned by the services. Callers match them with
+// errors.Is; the driver error, if any, stays in the wrap chain.
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid data")
+	// ErrVersionMismatch means the user was updated since the caller read
+	// it, so the caller's write would silently overwrite that change.
+	ErrVersionMismatch = errors.New("user version mismatch")
+	// ErrNotDeleted means a user asked to be restored is not in the trash.
+	ErrNotDeleted = errors.New("user is not deleted")
+)
+
+// ConstraintError reports a violated database constraint. It unwraps to
+// ErrConflict or ErrInvalid, and Message is safe to show to clients.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Message    string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *ConstraintError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// translateError converts Postgres integrity errors into a ConstraintError.
+// Other errors are returned unchanged.
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var kind error
+	switch pgErr.Code {
+	case "23505", "23503": // unique_violation, foreign_key_violation
+		kind = ErrConflict
+	case "23514", "23502", "22001": // check_violation, not_null_violation, string_data_right_truncation
+		kind = ErrInvalid
+	default:
+		return err
+	}
+
+	message := "the request violates a data constraint"
+	if pgErr.ConstraintName != "" {
+		message = fmt.Sprintf("the request violates constraint %q", pgErr.ConstraintName)
+	}
+	return &ConstraintError{Kind: kind, Constraint: pgErr.ConstraintName, Message: message, Err: err}
+}
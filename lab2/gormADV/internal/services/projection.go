@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidProjection is returned by ParseProjection for a field or relation
+// that does not exist.
+var ErrInvalidProjection = errors.New("invalid projection")
+
+// UserFields lists the columns of users a read can be limited to.
+var UserFields = []string{"id", "created_at", "updated_at", "deleted_at", "name", "age", "version"}
+
+// UserRelations lists the relations a read can expand.
+var UserRelations = []string{"profile"}
+
+// Projection limits what a read loads. Columns that are not loaded are left
+// at their zero value.
+type Projection struct {
+	// Fields are the columns of users to select; nil selects all of them.
+	Fields []string
+	// Profile preloads the user's profile.
+	Profile bool
+}
+
+// FullProjection loads every column and the profile.
+var FullProjection = Projection{Profile: true}
+
+// ParseProjection parses comma separated lists of fields, such as "id,name",
+// and of relations to include, such as "profile". Either may be empty.
+func ParseProjection(fields, include string) (Projection, error) {
+	var p Projection
+	for _, field := range splitList(fields) {
+		if !slices.Contains(UserFields, field) {
+			return p, fmt.Errorf("%w: unknown field %q, expected one of %s", ErrInvalidProjection, field, strings.Join(UserFields, ", "))
+		}
+		if !slices.Contains(p.Fields, field) {
+			p.Fields = append(p.Fields, field)
+		}
+	}
+	for _, relation := range splitList(include) {
+		if !slices.Contains(UserRelations, relation) {
+			return p, fmt.Errorf("%w: unknown relation %q, expected one of %s", ErrInvalidProjection, relation, strings.Join(UserRelations, ", "))
+		}
+		p.Profile = true
+	}
+	return p, nil
+}
+
+func splitList(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
+
+// selectColumns selects the projected columns along with required ones.
+// The ID is always selected with the profile, which is matched to users by
+// it.
+func (p Projection) selectColumns(db *gorm.DB, required ...string) *gorm.DB {
+	if p.Fields == nil {
+		return db
+	}
+	if p.Profile {
+		required = append(required, "id")
+	}
+	var columns []string
+	for _, field := range UserFields {
+		if slices.Contains(p.Fields, field) || slices.Contains(required, field) {
+			columns = append(columns, "users."+field)
+		}
+	}
+	return db.Select(columns)
+}
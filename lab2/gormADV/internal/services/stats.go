@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gorm.io/gorm"
+	"gormADV/internal/database"
+	"gormADV/internal/models"
+	"maps"
+	"slices"
+)
+
+// hasProfile matches users whose profile has a bio or a picture. Profiles
+// of users in the trash count if they were deleted along with the user.
+const hasProfile = "EXISTS (SELECT 1 FROM profiles WHERE profiles.user_id = users.id" +
+	" AND (profiles.deleted_at IS NULL OR profiles.deleted_at >= users.deleted_at)" +
+	" AND (profiles.bio <> '' OR profiles.profile_picture_url <> ''))"
+
+// maxHistogramBuckets bounds the histogram with its empty buckets filled
+// in, so that a narrow bucket width over a wide spread of ages cannot make
+// it arbitrarily long.
+const maxHistogramBuckets = 1000
+
+// GetUserStats summarises the users GetUsersWithProfiles would return: their
+// ages, with a histogram of buckets bucketWidth years wide, and how many of
+// them filled in their profile. Both queries run in one read-only snapshot
+// so they describe the same users.
+func GetUserStats(ctx context.Context, minAge, maxAge int, deleted DeletedFilter, bucketWidth int) (*models.UserStats, error) {
+	var summary struct {
+		Count, MinAge, MaxAge, WithProfile   int
+		AvgAge, P25, P50, P75, P90, P95, P99 float64
+	}
+	var buckets []struct {
+		Bucket, Count int
+	}
+
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := filterUsers(tx.Model(&models.User{}), minAge, maxAge, deleted).
+			Select("COUNT(*) AS count, COALESCE(MIN(age), 0) AS min_age, COALESCE(MAX(age), 0) AS max_age, " +
+				"COALESCE(AVG(age), 0) AS avg_age, " +
+				"COALESCE(percentile_cont(0.25) WITHIN GROUP (ORDER BY age), 0) AS p25, " +
+				"COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY age), 0) AS p50, " +
+				"COALESCE(percentile_cont(0.75) WITHIN GROUP (ORDER BY age), 0) AS p75, " +
+				"COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY age), 0) AS p90, " +
+				"COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY age), 0) AS p95, " +
+				"COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY age), 0) AS p99, " +
+				"COUNT(*) FILTER (WHERE " + hasProfile + ") AS with_profile").
+			Scan(&summary).Error
+		if err != nil {
+			return fmt.Errorf("failed to summarise users: %w", err)
+		}
+
+		err = filterUsers(tx.Model(&models.User{}), minAge, maxAge, deleted).
+			Select("age / ? * ? AS bucket, COUNT(*) AS count", bucketWidth, bucketWidth).
+			Group("bucket").
+			Scan(&buckets).Error
+		if err != nil {
+			return fmt.Errorf("failed to query age histogram: %w", err)
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.UserStats{
+		Count:       summary.Count,
+		MinAge:      summary.MinAge,
+		MaxAge:      summary.MaxAge,
+		AvgAge:      summary.AvgAge,
+		MedianAge:   summary.P50,
+		BucketWidth: bucketWidth,
+		Histogram:   []models.AgeBucket{},
+		WithProfile: summary.WithProfile,
+		Percentiles: map[string]float64{},
+	}
+	if summary.Count == 0 {
+		return stats, nil
+	}
+
+	stats.Percentiles = map[string]float64{
+		"p25": summary.P25, "p50": summary.P50, "p75": summary.P75,
+		"p90": summary.P90, "p95": summary.P95, "p99": summary.P99,
+	}
+	stats.ProfileShare = float64(summary.WithProfile) / float64(summary.Count)
+
+	counts := map[int]int{}
+	for _, bucket := range buckets {
+		counts[bucket.Bucket] = bucket.Count
+	}
+	stats.Histogram = ageHistogram(counts, stats.MinAge, stats.MaxAge, bucketWidth)
+	return stats, nil
+}
+
+// ageHistogram turns per-bucket counts, keyed by the lowest age of the
+// bucket, into a histogram covering every bucket from minAge to maxAge.
+// When that would take more than maxHistogramBuckets buckets, only the
+// buckets in counts are listed.
+func ageHistogram(counts map[int]int, minAge, maxAge, width int) []models.AgeBucket {
+	histogram := []models.AgeBucket{}
+	first := minAge / width * width
+	if (maxAge-first)/width < maxHistogramBuckets {
+		for i := range (maxAge-first)/width + 1 {
+			from := first + i*width
+			histogram = append(histogram, models.AgeBucket{From: from, To: from + width, Count: counts[from]})
+		}
+		return histogram
+	}
+	for _, from := range slices.Sorted(maps.Keys(counts)) {
+		histogram = append(histogram, models.AgeBucket{From: from, To: from + width, Count: counts[from]})
+	}
+	return histogram
+}
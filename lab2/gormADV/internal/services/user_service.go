@@ -1,79 +1,199 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gormADV/internal/audit"
 	"gormADV/internal/database"
 	"gormADV/internal/models"
-	"log"
+	"log/slog"
 )
 
-func CreateUserWithProfile(user *models.User) error {
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
+func CreateUserWithProfile(ctx context.Context, user *models.User) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(user).Error; err != nil {
-			return err
+			return translateError(err)
 		}
 
-		return nil
+		return recordAudit(tx, audit.ActionCreate, user.ID, nil, user)
 	})
 
 	if err != nil {
-		log.Printf("Failed to create user and profile: %v", err)
+		slog.WarnContext(ctx, "Failed to create user and profile", "error", err)
 		return err
 	}
 
-	log.Println("User and profile created successfully.")
+	slog.InfoContext(ctx, "Created user and profile", "user_id", user.ID)
 	return nil
 }
 
-func GetUsersWithProfiles(minAge, maxAge, page, pageSize int, sort string) ([]models.User, int, error) {
+// DeletedFilter selects users by whether they have been soft-deleted.
+type DeletedFilter int
+
+const (
+	ExcludeDeleted DeletedFilter = iota
+	IncludeDeleted
+	OnlyDeleted
+)
+
+func GetUsersWithProfiles(ctx context.Context, minAge, maxAge, page, pageSize int, sort Sort, deleted DeletedFilter, projection Projection) ([]models.User, int, error) {
 	var users []models.User
 	var totalCount int64
 
-	db := database.DB.Model(&models.User{})
-
-	if minAge > 0 {
-		db = db.Where("age >= ?", minAge)
-	}
-	if maxAge > 0 {
-		db = db.Where("age <= ?", maxAge)
-	}
+	db := filterUsers(database.DB.WithContext(ctx).Model(&models.User{}), minAge, maxAge, deleted)
 
 	if err := db.Count(&totalCount).Error; err != nil {
 		return nil, 0, err
 	}
 
-	switch sort {
-	case "name_asc":
-		db = db.Order("name ASC")
-	case "name_desc":
-		db = db.Order("name DESC")
+	if sort.usesProfile() {
+		db = db.Joins("LEFT JOIN profiles ON profiles.user_id = users.id AND (profiles.deleted_at IS NULL OR profiles.deleted_at >= users.deleted_at)")
+	}
+	db = projection.selectColumns(sort.apply(db))
+
+	// Unscoped reaches the preload too, so only show the profiles a
+	// restore would bring back alongside the live ones.
+	switch {
+	case !projection.Profile:
+	case deleted == ExcludeDeleted:
+		db = db.Preload("Profile")
 	default:
-		db = db.Order("id")
+		db = db.Preload("Profile", "profiles.deleted_at IS NULL OR profiles.deleted_at >= (SELECT users.deleted_at FROM users WHERE users.id = profiles.user_id)")
 	}
 
 	offset := (page - 1) * pageSize
-	if err := db.Preload("Profile").Offset(offset).Limit(pageSize).Find(&users).Error; err != nil {
+	if err := db.Offset(offset).Limit(pageSize).Find(&users).Error; err != nil {
 		return nil, 0, err
 	}
 
 	return users, int(totalCount), nil
 }
-func UpdateUserAndProfile(user *models.User, profile *models.Profile) error {
-	return database.DB.Transaction(func(tx *gorm.DB) error {
-
-		result := tx.Model(&models.User{}).
-			Where("id = ?", user.ID).
-			Updates(map[string]interface{}{
-				"name": user.Name,
-				"age":  user.Age,
-			})
+
+// filterUsers narrows a query on users by age and deletion state.
+func filterUsers(db *gorm.DB, minAge, maxAge int, deleted DeletedFilter) *gorm.DB {
+	switch deleted {
+	case IncludeDeleted:
+		db = db.Unscoped()
+	case OnlyDeleted:
+		db = db.Unscoped().Where("users.deleted_at IS NOT NULL")
+	}
+
+	if minAge > 0 {
+		db = db.Where("users.age >= ?", minAge)
+	}
+	if maxAge > 0 {
+		db = db.Where("users.age <= ?", maxAge)
+	}
+	return db
+}
+
+// ExportUsers passes every user that GetUsersWithProfiles would return,
+// across all pages, to fn together with its profile. The profile is joined
+// rather than preloaded so that all rows come from a single cursor and
+// memory use does not grow with the number of users.
+func ExportUsers(ctx context.Context, minAge, maxAge int, sort Sort, fn func(user *models.User) error) error {
+	db := database.DB.WithContext(ctx).Model(&models.User{}).
+		Select("users.id, users.created_at, users.updated_at, users.name, users.age, users.version, " +
+			"profiles.id, profiles.created_at, profiles.updated_at, profiles.bio, profiles.profile_picture_url").
+		Joins("LEFT JOIN profiles ON profiles.user_id = users.id AND profiles.deleted_at IS NULL")
+
+	if minAge > 0 {
+		db = db.Where("users.age >= ?", minAge)
+	}
+	if maxAge > 0 {
+		db = db.Where("users.age <= ?", maxAge)
+	}
+
+	db = sort.apply(db)
+
+	rows, err := db.Rows()
+	if err != nil {
+		return fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		var profileID sql.NullInt64
+		var profileCreatedAt, profileUpdatedAt sql.NullTime
+		var bio, pictureURL sql.NullString
+		err := rows.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Name, &user.Age, &user.Version,
+			&profileID, &profileCreatedAt, &profileUpdatedAt, &bio, &pictureURL)
+		if err != nil {
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		if profileID.Valid {
+			user.Profile = &models.Profile{
+				Model:             gorm.Model{ID: uint(profileID.Int64), CreatedAt: profileCreatedAt.Time, UpdatedAt: profileUpdatedAt.Time},
+				UserID:            user.ID,
+				Bio:               bio.String,
+				ProfilePictureURL: pictureURL.String,
+			}
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+	return nil
+}
+
+func GetUserWithProfile(ctx context.Context, userID uint, projection Projection) (*models.User, error) {
+	var user models.User
+	db := projection.selectColumns(database.DB.WithContext(ctx), "id", "updated_at", "version")
+	if projection.Profile {
+		db = db.Preload("Profile")
+	}
+	err := db.First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// UpdateUserAndProfile overwrites a user and, if profile is not nil, its
+// profile. The write only succeeds while the user is still at user.Version,
+// otherwise it fails with ErrVersionMismatch; a zero Version skips the
+// check. On success user.Version holds the new version.
+func UpdateUserAndProfile(ctx context.Context, user *models.User, profile *models.Profile) error {
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockUserWithProfile(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		var updated models.User
+		query := tx.Model(&updated).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "version"}}}).
+			Where("id = ?", user.ID)
+		if user.Version != 0 {
+			query = query.Where("version = ?", user.Version)
+		}
+		result := query.Updates(map[string]interface{}{
+			"name":    user.Name,
+			"age":     user.Age,
+			"version": gorm.Expr("version + 1"),
+		})
 		if result.Error != nil {
-			return fmt.Errorf("failed to update user: %w", result.Error)
+			return fmt.Errorf("failed to update user: %w", translateError(result.Error))
 		}
 		if result.RowsAffected == 0 {
-			return fmt.Errorf("user not found")
+			// The user is locked, so only its version can have moved on.
+			return ErrVersionMismatch
 		}
+		user.Version = updated.Version
+
+		after := *before
+		after.Name, after.Age, after.Version = user.Name, user.Age, user.Version
 
 		if profile != nil {
 			result = tx.Model(&models.Profile{}).
@@ -83,30 +203,136 @@ func UpdateUserAndProfile(user *models.User, profile *models.Profile) error {
 					"profile_picture_url": profile.ProfilePictureURL,
 				})
 			if result.Error != nil {
-				return fmt.Errorf("failed to update profile: %w", result.Error)
+				return fmt.Errorf("failed to update profile: %w", translateError(result.Error))
+			}
+			if after.Profile != nil {
+				updatedProfile := *after.Profile
+				updatedProfile.Bio, updatedProfile.ProfilePictureURL = profile.Bio, profile.ProfilePictureURL
+				after.Profile = &updatedProfile
 			}
+		}
+		return recordAudit(tx, audit.ActionUpdate, user.ID, before, &after)
+	})
+}
+
+// lockUserWithProfile loads a user and its profile and locks the user row
+// until tx ends.
+func lockUserWithProfile(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Profile").First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// PatchUserWithProfile locks a user and its profile, passes them to apply
+// and writes back only the fields apply changed, all in one transaction.
+// Setting the profile to nil deletes it; setting a missing one creates it.
+// Like UpdateUserAndProfile it checks version unless it is zero. Errors
+// from apply are returned unchanged.
+func PatchUserWithProfile(ctx context.Context, userID uint, version int, apply func(user *models.User) error) (*models.User, error) {
+	var user models.User
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		locked, err := lockUserWithProfile(tx, userID)
+		if err != nil {
+			return err
+		}
+		user = *locked
+		if version != 0 && version != user.Version {
+			return ErrVersionMismatch
+		}
+
+		current := user
+		if user.Profile != nil {
+			profile := *user.Profile
+			current.Profile = &profile
+		}
+		if err := apply(&user); err != nil {
+			return err
+		}
+
+		changed := false
+		switch {
+		case user.Profile == nil && current.Profile != nil:
+			if err := tx.Delete(&models.Profile{}, "user_id = ?", userID).Error; err != nil {
+				return fmt.Errorf("failed to delete profile: %w", translateError(err))
+			}
+			changed = true
+		case user.Profile != nil && current.Profile == nil:
+			user.Profile.UserID = userID
+			if err := tx.Create(user.Profile).Error; err != nil {
+				return fmt.Errorf("failed to create profile: %w", translateError(err))
+			}
+			changed = true
+		case user.Profile != nil:
+			changes := map[string]interface{}{}
+			if user.Profile.Bio != current.Profile.Bio {
+				changes["bio"] = user.Profile.Bio
+			}
+			if user.Profile.ProfilePictureURL != current.Profile.ProfilePictureURL {
+				changes["profile_picture_url"] = user.Profile.ProfilePictureURL
+			}
+			if len(changes) > 0 {
+				if err := tx.Model(&models.Profile{}).Where("user_id = ?", userID).Updates(changes).Error; err != nil {
+					return fmt.Errorf("failed to update profile: %w", translateError(err))
+				}
+				changed = true
+			}
+		}
 
+		changes := map[string]interface{}{}
+		if user.Name != current.Name {
+			changes["name"] = user.Name
 		}
-		return nil
+		if user.Age != current.Age {
+			changes["age"] = user.Age
+		}
+		if len(changes) == 0 && !changed {
+			return nil
+		}
+		// The version covers the profile as well, so any change bumps it.
+		changes["version"] = gorm.Expr("version + 1")
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(changes).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", translateError(err))
+		}
+
+		var patched models.User
+		if err := tx.Preload("Profile").First(&patched, userID).Error; err != nil {
+			return fmt.Errorf("failed to reload user: %w", err)
+		}
+		user = patched
+		return recordAudit(tx, audit.ActionUpdate, userID, &current, &user)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
-func DeleteUserWithProfile(userID uint) error {
-	err := database.DB.Transaction(func(tx *gorm.DB) error {
+// DeleteUserWithProfile moves a user and its profile to the trash. Both
+// stay in the table with deleted_at set until RestoreUserWithProfile.
+func DeleteUserWithProfile(ctx context.Context, userID uint) error {
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockUserWithProfile(tx, userID)
+		if err != nil {
+			return err
+		}
+
 		result := tx.Delete(&models.User{}, userID)
 		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return fmt.Errorf("user not found")
+			return translateError(result.Error)
 		}
 
 		result = tx.Delete(&models.Profile{}, "user_id = ?", userID)
 		if result.Error != nil {
-			return result.Error
+			return translateError(result.Error)
 		}
 
-		return nil
+		return recordAudit(tx, audit.ActionDelete, userID, before, nil)
 	})
 
 	if err != nil {
@@ -115,3 +341,47 @@ func DeleteUserWithProfile(userID uint) error {
 
 	return nil
 }
+
+// RestoreUserWithProfile brings a deleted user back together with the
+// profile that was deleted along with it. A profile removed earlier, e.g.
+// by a patch, stays deleted. It fails with ErrNotDeleted if the user is not
+// in the trash.
+func RestoreUserWithProfile(ctx context.Context, userID uint) (*models.User, error) {
+	var user models.User
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if !user.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+		deleted := user
+
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore user: %w", translateError(err))
+		}
+		// The profile is deleted in the same transaction as the user, a
+		// moment later.
+		err = tx.Unscoped().Model(&models.Profile{}).
+			Where("user_id = ? AND deleted_at >= ?", userID, user.DeletedAt.Time).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return fmt.Errorf("failed to restore profile: %w", translateError(err))
+		}
+
+		var restored models.User
+		if err := tx.Preload("Profile").First(&restored, userID).Error; err != nil {
+			return fmt.Errorf("failed to reload user: %w", err)
+		}
+		user = restored
+		return recordAudit(tx, audit.ActionRestore, userID, &deleted, &user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// RowsAffectedKey is the attribute key for the rows a statement returned or
+// changed, as GORM counts them.
+const RowsAffectedKey = attribute.Key("db.rows_affected")
+
+// spanKey is where the span of a statement is kept between the callbacks
+// around it.
+const spanKey = "tracing:span"
+
+// GormPlugin records a client span for every statement GORM runs, named
+// after its operation and table, with its text (placeholders, never the
+// arguments) and the number of rows it returned or changed. Register it with
+// db.Use.
+type GormPlugin struct{}
+
+var _ gorm.Plugin = GormPlugin{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	c := db.Callback()
+	return errors.Join(
+		c.Create().Before("gorm:create").Register("tracing:before_create", startStatement),
+		c.Create().After("gorm:create").Before("gorm:save_after_associations").Register("tracing:after_create", endStatement),
+		c.Query().Before("gorm:query").Register("tracing:before_query", startStatement),
+		c.Query().After("gorm:query").Before("gorm:preload").Register("tracing:after_query", endStatement),
+		c.Update().Before("gorm:update").Register("tracing:before_update", startStatement),
+		c.Update().After("gorm:update").Before("gorm:save_after_associations").Register("tracing:after_update", endStatement),
+		c.Delete().Before("gorm:delete").Register("tracing:before_delete", startStatement),
+		c.Delete().After("gorm:delete").Register("tracing:after_delete", endStatement),
+		c.Row().Before("gorm:row").Register("tracing:before_row", startStatement),
+		c.Row().After("gorm:row").Register("tracing:after_row", endStatement),
+		c.Raw().Before("gorm:raw").Register("tracing:before_raw", startStatement),
+		c.Raw().After("gorm:raw").Register("tracing:after_raw", endStatement),
+	)
+}
+
+// startStatement starts the span of the statement about to run. The
+// statement's context is left alone: the span ends before GORM preloads or
+// saves associations, and their statements become its siblings.
+func startStatement(db *gorm.DB) {
+	_, span := tracer().Start(db.Statement.Context, "SQL",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	db.InstanceSet(spanKey, span)
+}
+
+// endStatement names the span now that the SQL is known, records the
+// statement, its row count and any error, and ends it. A query finding
+// nothing is not a failure.
+func endStatement(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	sql := db.Statement.SQL.String()
+	name := strings.ToUpper(strings.SplitN(strings.TrimSpace(sql), " ", 2)[0])
+	if name == "" {
+		name = "SQL"
+	}
+	if table := db.Statement.Table; table != "" {
+		name += " " + table
+		span.SetAttributes(semconv.DBSQLTable(table))
+	}
+	span.SetName(name)
+	span.SetAttributes(semconv.DBStatement(sql), RowsAffectedKey.Int64(db.Statement.RowsAffected))
+
+	if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,122 @@
+package tracing_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gormADV/internal/tracing"
+	"strings"
+	"testing"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func setupDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Ошибка при создании мока: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("Не удалось подключиться к GORM с mock базой данных: %v", err)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		t.Fatalf("Не удалось подключить трассировку: %v", err)
+	}
+	return db, mock
+}
+
+func TestGormPluginSpans(t *testing.T) {
+	recorder := setupTracing(t)
+	db, mock := setupDB(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	db = db.WithContext(ctx)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE name <> \$1`).
+		WithArgs("secret").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "a").AddRow(2, "b"))
+	mock.ExpectExec(`UPDATE "users" SET "name"=\$1 WHERE id > \$2`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectExec(`DELETE FROM users`).WillReturnError(sql.ErrConnDone)
+
+	var users []user
+	if err := db.Find(&users, "name <> ?", "secret").Error; err != nil {
+		t.Fatalf("Ошибка запроса: %v", err)
+	}
+	if err := db.Model(&user{}).Where("id > ?", 0).Update("name", "x").Error; err != nil {
+		t.Fatalf("Ошибка изменения: %v", err)
+	}
+	if err := db.First(&user{}, 9).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Ожидалась ошибка gorm.ErrRecordNotFound, получили %v", err)
+	}
+	if err := db.Exec("DELETE FROM users").Error; err == nil {
+		t.Fatal("Ожидалась ошибка удаления")
+	}
+	parent.End()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Не все ожидания выполнены: %v", err)
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	want := []string{"SELECT users", "UPDATE users", "SELECT users", "DELETE", "request"}
+	if strings.Join(names, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("Неверные спаны: получили %v, ожидали %v", names, want)
+	}
+
+	for _, span := range spans[:4] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Спан %s не вложен в спан запроса", span.Name())
+		}
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("Спан %s: неверный вид %v", span.Name(), span.SpanKind())
+		}
+	}
+
+	query := attributes(spans[0])
+	if got := query["db.statement"].AsString(); got != `SELECT * FROM "users" WHERE name <> $1` {
+		t.Errorf("Неверный db.statement: %q", got)
+	}
+	if got := query["db.sql.table"].AsString(); got != "users" {
+		t.Errorf("Неверный db.sql.table: %q", got)
+	}
+	if got := query[tracing.RowsAffectedKey].AsInt64(); got != 2 {
+		t.Errorf("Неверное число строк: получили %d, ожидали 2", got)
+	}
+	for _, kv := range spans[0].Attributes() {
+		if kv.Value.Emit() == "secret" {
+			t.Errorf("Аргумент запроса попал в спан: %v", kv)
+		}
+	}
+
+	if got := attributes(spans[1])[tracing.RowsAffectedKey].AsInt64(); got != 3 {
+		t.Errorf("Неверное число изменённых строк: получили %d, ожидали 3", got)
+	}
+	if spans[2].Status().Code == codes.Error {
+		t.Errorf("Пустой результат отмечен как ошибка: %v", spans[2].Status())
+	}
+	if spans[3].Status().Code != codes.Error {
+		t.Errorf("Ошибка не отмечена в спане: %v", spans[3].Status())
+	}
+}
@@ -0,0 +1,121 @@
+// Package tracing sets up OpenTelemetry tracing: spans for HTTP requests and
+// the statements GORM runs, exported over OTLP or written as JSON, and W3C
+// trace context propagation so that a caller's traceparent header continues its
+// trace here.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName names this service in its spans unless OTEL_SERVICE_NAME
+// says otherwise.
+const ServiceName = "gormADV"
+
+const instrumentationName = "gormADV/internal/tracing"
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config selects where spans go. The OTLP exporter is configured by the
+// standard OTEL_EXPORTER_OTLP_* variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT.
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterOTLP, ExporterStdout or
+	// ExporterFile.
+	Exporter string
+	// File is where ExporterFile appends spans, one JSON object per span.
+	File string
+}
+
+// Setup installs a tracer provider exporting as cfg says and the W3C trace
+// context and baggage propagators. The returned function flushes the spans
+// not yet exported and stops the provider. With ExporterNone spans are
+// still propagated but not recorded.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	closeFile := func() error { return nil }
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		closeFile = f.Close
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		closeFile()
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	// Attributes from the environment (OTEL_SERVICE_NAME,
+	// OTEL_RESOURCE_ATTRIBUTES) take precedence over the default name.
+	res, err := resource.Merge(resource.NewSchemaless(semconv.ServiceName(ServiceName)), resource.Default())
+	if err != nil {
+		closeFile()
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeFile(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// Middleware starts a server span for every request routed by the router it
+// is used on, named after the route template and continuing the trace of
+// the request's traceparent header. Requests for the paths in skip, such as
+// health probes and metric scrapes, are not traced.
+func Middleware(skip ...string) mux.MiddlewareFunc {
+	return otelmux.Middleware(ServiceName, otelmux.WithFilter(func(r *http.Request) bool {
+		for _, path := range skip {
+			if r.URL.Path == path {
+				return false
+			}
+		}
+		return true
+	}))
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
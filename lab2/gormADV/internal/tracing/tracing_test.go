@@ -0,0 +1,63 @@
+package tracing_test
+
+import (
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gormADV/internal/tracing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setupTracing records the spans started during the test.
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestMiddlewarePropagatesTraceContext(t *testing.T) {
+	recorder := setupTracing(t)
+
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware("/healthz"))
+	var got trace.SpanContext
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	})
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Запрос не продолжил след вызывающего: %s", got.TraceID())
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Неверное число спанов: получили %d, ожидали 1", len(spans))
+	}
+	if spans[0].Name() != "/users/{id}" || spans[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Неверный спан запроса: %s, родитель %s", spans[0].Name(), spans[0].Parent().SpanID())
+	}
+}
@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"errors"
+	"gormADV/internal/problem"
+	"gormADV/internal/services"
+	"log/slog"
+	"net/http"
+)
+
+// writeServiceError maps an error returned by the services to an HTTP
+// response. Driver messages are logged rather than sent to the client.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var constraintErr *services.ConstraintError
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		problem.Write(w, r, http.StatusNotFound, "User not found")
+	case errors.Is(err, services.ErrNotDeleted):
+		problem.Write(w, r, http.StatusConflict, "User is not deleted")
+	case errors.As(err, &constraintErr) && errors.Is(err, services.ErrConflict):
+		problem.Write(w, r, http.StatusConflict, constraintErr.Message)
+	case errors.As(err, &constraintErr) && errors.Is(err, services.ErrInvalid):
+		problem.Write(w, r, http.StatusUnprocessableEntity, constraintErr.Message)
+	case errors.Is(err, services.ErrConflict):
+		problem.Write(w, r, http.StatusConflict, "Conflict")
+	case errors.Is(err, services.ErrInvalid):
+		problem.Write(w, r, http.StatusUnprocessableEntity, "Invalid data")
+	case timedOut(r, err):
+		problem.Write(w, r, http.StatusGatewayTimeout, "Request timed out")
+	default:
+		slog.ErrorContext(r.Context(), "Request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, "Internal server error")
+	}
+}
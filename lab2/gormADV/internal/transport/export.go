@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"gormADV/internal/models"
+	"gormADV/internal/problem"
+	"gormADV/internal/services"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushEvery is how many users are written between flushes of an
+// export, so clients receive data while the query is still running.
+const exportFlushEvery = 100
+
+// flatUser is a user with its profile columns inlined, as exported with
+// flatten=true.
+type flatUser struct {
+	ID                uint      `json:"id"`
+	Name              string    `json:"name"`
+	Age               int       `json:"age"`
+	Version           int       `json:"version"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	ProfileBio        string    `json:"profile_bio"`
+	ProfilePictureURL string    `json:"profile_picture_url"`
+}
+
+func flatten(user *models.User) flatUser {
+	flat := flatUser{
+		ID:        user.ID,
+		Name:      user.Name,
+		Age:       user.Age,
+		Version:   user.Version,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	if user.Profile != nil {
+		flat.ProfileBio = user.Profile.Bio
+		flat.ProfilePictureURL = user.Profile.ProfilePictureURL
+	}
+	return flat
+}
+
+// userEncoder writes users in one export format.
+type userEncoder interface {
+	// encode writes one user, possibly into a buffer.
+	encode(user *models.User) error
+	// flush writes buffered output to the underlying writer.
+	flush() error
+	// close ends the document and flushes.
+	close() error
+}
+
+// exportFormat describes one value of the format parameter.
+type exportFormat struct {
+	contentType string
+	extension   string
+	newEncoder  func(w io.Writer, flat bool) (userEncoder, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {"text/csv; charset=utf-8", "csv", newCSVEncoder},
+	"ndjson": {"application/x-ndjson", "ndjson", newNDJSONEncoder},
+	"json":   {"application/json", "json", newJSONArrayEncoder},
+}
+
+// exportValue returns what the JSON formats write for user.
+func exportValue(user *models.User, flat bool) interface{} {
+	if flat {
+		return flatten(user)
+	}
+	return user
+}
+
+// csvText returns s as a CSV cell that spreadsheets show as text. A cell
+// starting with =, +, -, @, a tab or a carriage return is read as a
+// formula, so such values get a leading apostrophe.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type csvEncoder struct {
+	w    *csv.Writer
+	flat bool
+}
+
+func newCSVEncoder(w io.Writer, flat bool) (userEncoder, error) {
+	enc := &csvEncoder{w: csv.NewWriter(w), flat: flat}
+	header := []string{"id", "name", "age", "version", "created_at", "updated_at"}
+	if flat {
+		header = append(header, "profile_bio", "profile_picture_url")
+	}
+	return enc, enc.w.Write(header)
+}
+
+func (e *csvEncoder) encode(user *models.User) error {
+	u := flatten(user)
+	record := []string{
+		strconv.FormatUint(uint64(u.ID), 10),
+		csvText(u.Name),
+		strconv.Itoa(u.Age),
+		strconv.Itoa(u.Version),
+		u.CreatedAt.Format(time.RFC3339),
+		u.UpdatedAt.Format(time.RFC3339),
+	}
+	if e.flat {
+		record = append(record, csvText(u.ProfileBio), csvText(u.ProfilePictureURL))
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	return e.flush()
+}
+
+type ndjsonEncoder struct {
+	enc  *json.Encoder
+	flat bool
+}
+
+func newNDJSONEncoder(w io.Writer, flat bool) (userEncoder, error) {
+	return &ndjsonEncoder{enc: json.NewEncoder(w), flat: flat}, nil
+}
+
+func (e *ndjsonEncoder) encode(user *models.User) error {
+	return e.enc.Encode(exportValue(user, e.flat))
+}
+
+func (e *ndjsonEncoder) flush() error { return nil }
+func (e *ndjsonEncoder) close() error { return nil }
+
+// jsonArrayEncoder writes a single JSON array one element at a time.
+type jsonArrayEncoder struct {
+	w     io.Writer
+	flat  bool
+	count int
+}
+
+func newJSONArrayEncoder(w io.Writer, flat bool) (userEncoder, error) {
+	_, err := io.WriteString(w, "[")
+	return &jsonArrayEncoder{w: w, flat: flat}, err
+}
+
+func (e *jsonArrayEncoder) encode(user *models.User) error {
+	data, err := json.Marshal(exportValue(user, e.flat))
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonArrayEncoder) flush() error { return nil }
+
+func (e *jsonArrayEncoder) close() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ExportUsers streams all matching users. The download is bounded by the
+// route deadline rather than by WRITE_TIMEOUT: 10 minutes by default, or
+// what ROUTE_TIMEOUTS sets, e.g. ROUTE_TIMEOUTS="GET /users/export=30m".
+// @Summary     Export users
+// @Description Stream every user matching the filters of GET /users, without paging, as CSV, newline-delimited JSON or a JSON array. Rows are read from a single database cursor and flushed to the client as they arrive, so exports of any size use constant memory.
+// @Description With flatten=true the profile's bio and picture URL become profile_bio and profile_picture_url columns of the user; otherwise CSV carries only the user's columns and JSON nests the profile as in GET /users.
+// @Description In CSV, text starting with =, +, -, @, a tab or a carriage return is prefixed with an apostrophe so that spreadsheets do not run it as a formula.
+// @Description If the export fails after it has started, the connection is closed before the document is complete.
+// @Description The whole download must finish within the route timeout (10 minutes by default, unless ROUTE_TIMEOUTS sets another for GET /users/export); slower exports are cut off.
+// @Tags        users
+// @Produce     text/csv
+// @Produce     application/x-ndjson
+// @Produce     json
+// @Param       format  query    string false "Export format" Enums(csv, ndjson, json) default(csv)
+// @Param       flatten query    bool   false "Inline the profile columns"
+// @Param       min_age query    int    false "Minimum Age"
+// @Param       max_age query    int    false "Maximum Age"
+// @Param       sort    query    string false "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id."
+// @Success     200     {array}  models.User
+// @Failure     400     {object} problem.Details "Invalid format or sort"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
+// @Router      /users/export [get]
+func ExportUsers(w http.ResponseWriter, r *http.Request) {
+	streamDeadlines(w, r)
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid format")
+		return
+	}
+	flat, _ := strconv.ParseBool(r.URL.Query().Get("flatten"))
+	minAge, _ := strconv.Atoi(r.URL.Query().Get("min_age"))
+	maxAge, _ := strconv.Atoi(r.URL.Query().Get("max_age"))
+	sort, err := services.ParseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The response starts with the first user, so errors that happen
+	// before that still get a proper status.
+	rc := http.NewResponseController(w)
+	var enc userEncoder
+	start := func() error {
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format.extension+`"`)
+		var err error
+		enc, err = format.newEncoder(w, flat)
+		return err
+	}
+
+	written := 0
+	err = services.ExportUsers(r.Context(), minAge, maxAge, sort, func(user *models.User) error {
+		if enc == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := enc.encode(user); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			if err := enc.flush(); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil && enc == nil {
+		err = start()
+	}
+	if err == nil {
+		err = enc.close()
+	}
+	if err != nil && enc == nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if err != nil {
+		// The status line is gone; abort the connection so the client
+		// cannot mistake a truncated export for a complete one.
+		slog.ErrorContext(r.Context(), "Export failed", "method", r.Method, "path", r.URL.Path, "written", written, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,65 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"gormADV/internal/health"
+	"gormADV/internal/problem"
+	"gormADV/internal/transport"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	var started health.Flag
+	checker := health.NewChecker(50 * time.Millisecond)
+	checker.Add("migrations", started.Check)
+	checker.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	r := mux.NewRouter()
+	transport.RegisterHealthRoutes(r, transport.NewHealthHandler(checker))
+	api := r.NewRoute().Subrouter()
+	api.Use(transport.RequireStarted(&started))
+	api.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	if rr := get("/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("/healthz: неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusOK)
+	}
+	if rr := get("/users"); rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != problem.ContentType {
+		t.Errorf("/users до запуска: получили %v %q, ожидали %v", rr.Code, rr.Header().Get("Content-Type"), http.StatusServiceUnavailable)
+	}
+
+	rr := get("/readyz")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz: неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	var report health.Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Не удалось разобрать ответ: %v", err)
+	}
+	want := health.Report{Status: health.StatusUnavailable, Checks: map[string]health.Result{
+		"migrations": {Status: health.StatusUnavailable, Error: "not completed yet"},
+		"database":   {Status: health.StatusUnavailable, Error: context.DeadlineExceeded.Error()},
+	}}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Неверный отчёт: получили %+v, ожидали %+v", report, want)
+	}
+
+	started.Set()
+	if rr := get("/users"); rr.Code != http.StatusOK {
+		t.Errorf("/users после запуска: неверный код статуса: получили %v, ожидали %v", rr.Code, http.StatusOK)
+	}
+}
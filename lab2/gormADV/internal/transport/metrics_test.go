@@ -0,0 +1,62 @@
+package transport_test
+
+import (
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gormADV/internal/metrics"
+	"gormADV/internal/transport"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the value of every series served by metrics.Handler.
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	series := map[string]float64{}
+	for _, line := range strings.Split(rr.Body.String(), "\n") {
+		name, value, ok := strings.Cut(line, " ")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("Неверное значение метрики %q: %v", line, err)
+		}
+		series[name] = v
+	}
+	return series
+}
+
+func TestMetrics(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WithArgs(42, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	before := scrapeMetrics(t)
+	for _, url := range []string{"/users/42", "/users?sort=email"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	after := scrapeMetrics(t)
+
+	for series, want := range map[string]float64{
+		`http_requests_total{code="404",method="get",route="/users/{id}"}`:                 1,
+		`http_requests_total{code="400",method="get",route="/users"}`:                      1,
+		`http_request_duration_seconds_count{code="404",method="get",route="/users/{id}"}`: 1,
+	} {
+		if got := after[series] - before[series]; got != want {
+			t.Errorf("%s: получили прирост %v, ожидали %v", series, got, want)
+		}
+	}
+	if got, ok := after[`http_requests_in_flight{method="GET",route="/users/{id}"}`]; !ok || got != 0 {
+		t.Errorf("Неверное число запросов в обработке: получили %v, ожидали 0", got)
+	}
+}
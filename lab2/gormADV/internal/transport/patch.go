@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"gormADV/internal/config"
+	"gormADV/internal/models"
+	"gormADV/internal/patch"
+	"gormADV/internal/problem"
+	"net/http"
+)
+
+// patchUser applies a patch document to user in place and validates the
+// result. IDs, timestamps and the version are read-only.
+func patchUser(user *models.User, apply patch.Func, body []byte) error {
+	doc, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %w", err)
+	}
+	patched, err := apply(doc, body)
+	if err != nil {
+		return err
+	}
+
+	var result models.User
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&result); err != nil {
+		return fmt.Errorf("%w: %v", patch.ErrCannotApply, err)
+	}
+	if result.ID != user.ID {
+		return fmt.Errorf("%w: ID cannot be changed", patch.ErrCannotApply)
+	}
+	result.Model = user.Model
+	result.Version = user.Version
+	if result.Profile != nil {
+		result.Profile.Model = gorm.Model{}
+		if user.Profile != nil {
+			result.Profile.Model = user.Profile.Model
+		}
+		result.Profile.UserID = user.ID
+	}
+
+	if err := config.Validate.Struct(result); err != nil {
+		return err
+	}
+	*user = result
+	return nil
+}
+
+// patchVersion returns the version a patch document expects the user to
+// have: the top-level version member of a merge patch or the value of a
+// test operation on /version in a JSON Patch. It returns 0 if there is none.
+func patchVersion(body []byte) int {
+	var merge struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(body, &merge); err == nil {
+		return merge.Version
+	}
+
+	var ops []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return 0
+	}
+	for _, op := range ops {
+		var version int
+		if op.Op == "test" && op.Path == "/version" && json.Unmarshal(op.Value, &version) == nil {
+			return version
+		}
+	}
+	return 0
+}
+
+// writePatchError maps an error from a PATCH request to an HTTP response,
+// falling back to writeServiceError for errors that did not come from the
+// patch itself.
+func writePatchError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrs validator.ValidationErrors
+	switch {
+	case errors.Is(err, patch.ErrMalformed):
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, patch.ErrTestFailed):
+		problem.Write(w, r, http.StatusConflict, err.Error())
+	case errors.As(err, &validationErrs):
+		problem.Validation(w, r, http.StatusUnprocessableEntity, validationErrs)
+	case errors.Is(err, patch.ErrCannotApply):
+		problem.Write(w, r, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeServiceError(w, r, err)
+	}
+}
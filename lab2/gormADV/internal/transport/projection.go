@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"encoding/json"
+	"gormADV/internal/models"
+	"gormADV/internal/services"
+	"net/http"
+)
+
+// fieldMembers maps the projectable columns whose JSON member is named
+// differently, inherited from gorm.Model.
+var fieldMembers = map[string]string{
+	"id":         "ID",
+	"created_at": "CreatedAt",
+	"updated_at": "UpdatedAt",
+	"deleted_at": "DeletedAt",
+}
+
+// projection parses the fields and include parameters of r. Without either
+// the whole user and its profile are returned, as before both existed.
+func projection(r *http.Request) (services.Projection, error) {
+	query := r.URL.Query()
+	include := query.Get("include")
+	if _, ok := query["include"]; !ok && query.Get("fields") == "" {
+		include = "profile"
+	}
+	return services.ParseProjection(query.Get("fields"), include)
+}
+
+// projectUser returns the JSON form of user limited to p. The profile member
+// is present only when it was included.
+func projectUser(user models.User, p services.Projection) interface{} {
+	if p.Fields == nil && p.Profile {
+		return user
+	}
+
+	data, _ := json.Marshal(user)
+	var members map[string]json.RawMessage
+	json.Unmarshal(data, &members)
+
+	fields := p.Fields
+	if fields == nil {
+		fields = services.UserFields
+	}
+	view := make(map[string]json.RawMessage, len(fields)+1)
+	for _, field := range fields {
+		member := field
+		if name, ok := fieldMembers[field]; ok {
+			member = name
+		}
+		view[member] = members[member]
+	}
+	if p.Profile {
+		view["profile"] = members["profile"]
+	}
+	return view
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"gormADV/internal/problem"
+	"gormADV/internal/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ways of telling clients apart for rate limiting.
+const (
+	ClientByIP     = "ip"
+	ClientByAPIKey = "api_key"
+	ClientByJWTSub = "jwt_sub"
+)
+
+// ClientKey tells which client a request comes from.
+type ClientKey struct {
+	// By is ClientByIP, ClientByAPIKey (the X-API-Key header) or
+	// ClientByJWTSub (the sub claim of a bearer token). Requests without
+	// the key are told apart by IP.
+	//
+	// This service does not check API keys or token signatures: keying by
+	// them is only sound behind a gateway that does, or else a client can
+	// make up a new key for every request.
+	By string
+	// TrustForwardedFor takes the client IP from the last X-Forwarded-For
+	// entry, the one added by the proxy in front of the service.
+	TrustForwardedFor bool
+}
+
+// Of returns the client r comes from.
+func (k ClientKey) Of(r *http.Request) string {
+	switch k.By {
+	case ClientByAPIKey:
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			return "key:" + key
+		}
+	case ClientByJWTSub:
+		if sub := jwtSubject(r); sub != "" {
+			return "sub:" + sub
+		}
+	}
+	return "ip:" + k.ip(r)
+}
+
+func (k ClientKey) ip(r *http.Request) string {
+	if k.TrustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// jwtSubject returns the unverified sub claim of the request's bearer
+// token, or "".
+func jwtSubject(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Sub
+}
+
+// RateLimit refuses requests over their client's limit for the route with
+// 429 and a Retry-After header. Limited routes report the client's quota in
+// the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers of every response.
+func RateLimit(limiter *ratelimit.Limiter, key ClientKey) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.URL.Path
+			if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				route = template
+			}
+			d := limiter.Allow(time.Now(), r.Method+" "+route, key.Of(r))
+			if d.Limit.Unlimited() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(d.Limit.Requests))
+			header.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			header.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", d.Limit.Requests, ceilSeconds(d.Limit.Period)))
+			if !d.Allowed {
+				header.Set("Retry-After", ceilSeconds(d.RetryAfter))
+				problem.Write(w, r, http.StatusTooManyRequests,
+					fmt.Sprintf("Rate limit of %d requests per %s exceeded", d.Limit.Requests, d.Limit.Period))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds formats d as whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,107 @@
+package transport_test
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"gormADV/internal/problem"
+	"gormADV/internal/ratelimit"
+	"gormADV/internal/transport"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.Limit{}, map[string]ratelimit.Limit{
+		"GET /users/{id}": {Requests: 2, Period: time.Minute},
+	}, 0)
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r, transport.RateLimit(limiter, transport.ClientKey{By: transport.ClientByAPIKey}))
+
+	// The IDs are invalid, so the requests never reach the database.
+	request := func(url, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rr := request("/users/x", "alice")
+		if rr.Code == http.StatusTooManyRequests {
+			t.Fatalf("Запрос %d отклонён до исчерпания лимита", i+1)
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != wantRemaining {
+			t.Errorf("Запрос %d: неверные заголовки лимита: %v", i+1, rr.Header())
+		}
+	}
+
+	before := scrapeMetrics(t)
+	rr := request("/users/x", "alice")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Неверный статус: получили %d, ожидали %d", rr.Code, http.StatusTooManyRequests)
+	}
+	series := `http_requests_total{code="429",method="get",route="/users/{id}"}`
+	if got := scrapeMetrics(t)[series] - before[series]; got != 1 {
+		t.Errorf("Отказ не учтён в метриках маршрута: прирост %s %v", series, got)
+	}
+	if rr.Header().Get("Retry-After") != "30" || rr.Header().Get("RateLimit-Reset") != "60" ||
+		rr.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("Неверные заголовки отказа: %v", rr.Header())
+	}
+	var details problem.Details
+	if err := json.NewDecoder(rr.Body).Decode(&details); err != nil || details.Status != http.StatusTooManyRequests {
+		t.Errorf("Ответ не в формате problem: %v %+v", err, details)
+	}
+	if details.RequestID == "" || details.RequestID != rr.Header().Get("X-Request-ID") {
+		t.Errorf("Отказ без ID запроса: %q, заголовок %q", details.RequestID, rr.Header().Get("X-Request-ID"))
+	}
+
+	if rr := request("/users/y", "alice"); rr.Code != http.StatusTooManyRequests {
+		t.Error("Лимит маршрута должен считаться по шаблону, а не по пути")
+	}
+	if rr := request("/users/x", "bob"); rr.Code == http.StatusTooManyRequests {
+		t.Error("Другой клиент не должен делить лимит с первым")
+	}
+	if rr := request("/users/x/history", "alice"); rr.Code == http.StatusTooManyRequests || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("Маршрут без лимита ограничен: %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	// {"sub":"alice"}
+	token := "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9.c2ln"
+	tests := []struct {
+		name   string
+		key    transport.ClientKey
+		header map[string]string
+		want   string
+	}{
+		{name: "ip", key: transport.ClientKey{By: transport.ClientByIP}, want: "ip:192.0.2.1"},
+		{name: "untrusted forwarded", key: transport.ClientKey{By: transport.ClientByIP},
+			header: map[string]string{"X-Forwarded-For": "10.0.0.1"}, want: "ip:192.0.2.1"},
+		{name: "trusted forwarded", key: transport.ClientKey{By: transport.ClientByIP, TrustForwardedFor: true},
+			header: map[string]string{"X-Forwarded-For": "10.0.0.1, 10.0.0.2"}, want: "ip:10.0.0.2"},
+		{name: "api key", key: transport.ClientKey{By: transport.ClientByAPIKey},
+			header: map[string]string{"X-API-Key": "k1"}, want: "key:k1"},
+		{name: "jwt", key: transport.ClientKey{By: transport.ClientByJWTSub},
+			header: map[string]string{"Authorization": "Bearer " + token}, want: "sub:alice"},
+		{name: "malformed jwt", key: transport.ClientKey{By: transport.ClientByJWTSub},
+			header: map[string]string{"Authorization": "Bearer nope"}, want: "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if got := tt.key.Of(req); got != tt.want {
+				t.Errorf("Неверный ключ клиента: получили %q, ожидали %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"gormADV/internal/config"
+	"gormADV/internal/metrics"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// handle registers fn for method and path behind mw, cancelling its context
+// after the route's configured timeout, tagging it for the audit trail and
+// recording its metrics.
+func handle(r *mux.Router, method, path string, fn http.HandlerFunc, mw []mux.MiddlewareFunc) {
+	var next http.Handler = fn
+	for i := len(mw) - 1; i >= 0; i-- {
+		next = mw[i](next)
+	}
+	r.Handle(path, metrics.Instrument(method, path, withTimeout(config.AppConfig.Timeout(method, path), withAuditInfo(next.ServeHTTP)))).Methods(method)
+}
+
+// withTimeout cancels the request context after d. Queries issued through
+// db.WithContext with that context abort once the deadline passes.
+func withTimeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// timedOut reports whether err was caused by the request deadline. pgx does
+// not always wrap context.DeadlineExceeded, so the request context is checked
+// as well.
+func timedOut(r *http.Request, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
+
+// streamDeadlines gives a streaming request the route deadline on its
+// connection as well. The server's READ_TIMEOUT and WRITE_TIMEOUT would
+// otherwise close the connection of a large download early, whatever
+// ROUTE_TIMEOUTS allows; without a route deadline they are cleared.
+// Writers that cannot set deadlines, as in tests, are left alone.
+func streamDeadlines(w http.ResponseWriter, r *http.Request) {
+	deadline, _ := r.Context().Deadline()
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+}
@@ -0,0 +1,47 @@
+package transport_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"gormADV/internal/transport"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportOutlastsWriteTimeout(t *testing.T) {
+	setupMockDB(t)
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	// The rows take three times the server's WriteTimeout to arrive.
+	mock.ExpectQuery(`SELECT users\.id, .* FROM "users" LEFT JOIN profiles`).
+		WillDelayFor(300 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "name", "age", "version", "id", "created_at", "updated_at", "bio", "profile_picture_url"}).
+			AddRow(1, created, created, "John Doe", 25, 1, nil, nil, nil, nil, nil))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/users/export")
+	if err != nil {
+		t.Fatalf("Запрос оборвался: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Выгрузка оборвалась: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "1,John Doe,25,1") {
+		t.Errorf("Неверный ответ %d: %q", resp.StatusCode, body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
@@ -2,20 +2,42 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"gormADV/internal/config"
 	"gormADV/internal/models"
+	"gormADV/internal/patch"
+	"gormADV/internal/problem"
 	"gormADV/internal/services"
+	"io"
 	"net/http"
 	"strconv"
 )
 
-// RegisterRoutes registers all routes for the application.
-func RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/users", GetUsers).Methods("GET")
-	r.HandleFunc("/users", CreateUser).Methods("POST")
-	r.HandleFunc("/users/{id}", UpdateUser).Methods("PUT")
-	r.HandleFunc("/users/{id}", DeleteUser).Methods("DELETE")
+// RegisterRoutes registers all routes for the application. mw runs inside
+// each route's metrics, timeout and request ID, so requests it refuses, e.g.
+// by RequireStarted or RateLimit, are measured and logged like the others.
+func RegisterRoutes(r *mux.Router, mw ...mux.MiddlewareFunc) {
+	handle(r, "GET", "/users", GetUsers, mw)
+	handle(r, "POST", "/users", CreateUser, mw)
+	handle(r, "GET", "/users/trash", GetTrash, mw)
+	handle(r, "GET", "/users/export", ExportUsers, mw)
+	handle(r, "GET", "/users/stats", GetUserStats, mw)
+	handle(r, "GET", "/users/{id}", GetUser, mw)
+	handle(r, "PUT", "/users/{id}", UpdateUser, mw)
+	handle(r, "PATCH", "/users/{id}", PatchUser, mw)
+	handle(r, "DELETE", "/users/{id}", DeleteUser, mw)
+	handle(r, "POST", "/users/{id}/restore", RestoreUser, mw)
+	handle(r, "GET", "/users/{id}/history", GetUserHistory, mw)
+	handle(r, "GET", "/audit", GetAudit, mw)
+
+	r.NotFoundHandler = withAuditInfo(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusNotFound, "No such resource")
+	})
+	r.MethodNotAllowedHandler = withAuditInfo(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on this resource")
+	})
 }
 
 // GetUsers @Summary Get list of users
@@ -27,16 +49,61 @@ func RegisterRoutes(r *mux.Router) {
 // @Param   max_age query int false "Maximum Age"
 // @Param   page query int false "Page number"
 // @Param   page_size query int false "Page size"
-// @Param   sort query string false "Sort by name in ascending or descending order"
+// @Param   sort query string false "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id."
+// @Param   include_deleted query bool false "Also list users in the trash"
+// @Param   fields query string false "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version"
+// @Param   include query string false "Relations to return; only profile. Defaults to profile unless fields is given"
 // @Success 200 {object} models.UserListResponse
-// @Failure 500 {string} string "Internal Server Error"
+// @Failure 400 {object} problem.Details "Invalid sort or projection"
+// @Failure 429 {object} problem.Details "Too many requests"
+// @Failure 500 {object} problem.Details "Internal Server Error"
+// @Failure 504 {object} problem.Details "Request timed out"
 // @Router /users [get]
 func GetUsers(w http.ResponseWriter, r *http.Request) {
+	deleted := services.ExcludeDeleted
+	if include, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted")); include {
+		deleted = services.IncludeDeleted
+	}
+	listUsers(w, r, deleted)
+}
+
+// GetTrash lists deleted users.
+// @Summary     List deleted users
+// @Description Get a paginated list of soft-deleted users. Accepts the same filters, sorting and pagination as GET /users. Each user carries the profile that was deleted with it.
+// @Tags        users
+// @Produce     json
+// @Param       min_age   query    int    false "Minimum Age"
+// @Param       max_age   query    int    false "Maximum Age"
+// @Param       page      query    int    false "Page number"
+// @Param       page_size query    int    false "Page size"
+// @Param       sort      query    string false "Comma separated field:direction pairs, e.g. age:desc,name:asc. Fields: id, name, age, created_at, updated_at, profile.bio, profile.profile_picture_url; direction asc (default) or desc. Ties are broken by id."
+// @Param       fields    query    string false "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version"
+// @Param       include   query    string false "Relations to return; only profile. Defaults to profile unless fields is given"
+// @Success     200       {object} models.UserListResponse
+// @Failure     400       {object} problem.Details "Invalid sort or projection"
+// @Failure     429       {object} problem.Details "Too many requests"
+// @Failure     500       {object} problem.Details "Internal Server Error"
+// @Failure     504       {object} problem.Details "Request timed out"
+// @Router      /users/trash [get]
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	listUsers(w, r, services.OnlyDeleted)
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request, deleted services.DeletedFilter) {
 	minAge, _ := strconv.Atoi(r.URL.Query().Get("min_age"))
 	maxAge, _ := strconv.Atoi(r.URL.Query().Get("max_age"))
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
-	sort := r.URL.Query().Get("sort")
+	sort, err := services.ParseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	projection, err := projection(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if page <= 0 {
 		page = 1
@@ -45,22 +112,27 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		pageSize = 10
 	}
 
-	users, totalCount, err := services.GetUsersWithProfiles(minAge, maxAge, page, pageSize, sort)
+	users, totalCount, err := services.GetUsersWithProfiles(r.Context(), minAge, maxAge, page, pageSize, sort, deleted, projection)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, r, err)
 		return
 	}
 
 	totalPages := (totalCount + pageSize - 1) / pageSize
 
+	views := make([]interface{}, len(users))
+	for i, user := range users {
+		views[i] = projectUser(user, projection)
+	}
+
 	response := struct {
-		Users      []models.User `json:"users"`
+		Users      []interface{} `json:"users"`
 		TotalItems int           `json:"total_items"`
 		Page       int           `json:"page"`
 		PageSize   int           `json:"page_size"`
 		TotalPages int           `json:"total_pages"`
 	}{
-		Users:      users,
+		Users:      views,
 		TotalItems: totalCount,
 		Page:       page,
 		PageSize:   pageSize,
@@ -71,31 +143,86 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// GetUser returns a single user.
+// @Summary     Get user
+// @Description Get a user and its profile by ID. The response carries a strong ETag derived from the data and the requested fields and relations, and a Last-Modified date; sending them back in If-None-Match or If-Modified-Since returns 304 Not Modified while the user is unchanged. Only the ETag of the whole user is accepted in If-Match by writes.
+// @Tags        users
+// @Produce     json
+// @Param       id                path     int    true  "User ID"
+// @Param       If-None-Match     header   string false "ETag from a previous response"
+// @Param       If-Modified-Since header   string false "Last-Modified from a previous response"
+// @Param       fields            query    string false "Comma separated user columns to return, e.g. id,name. Fields: id, created_at, updated_at, deleted_at, name, age, version"
+// @Param       include           query    string false "Relations to return; only profile. Defaults to profile unless fields is given"
+// @Success     200 {object} models.User
+// @Success     304 {string} string "Not Modified"
+// @Failure     400 {object} problem.Details "Invalid user ID or projection"
+// @Failure     404 {object} problem.Details "User not found"
+// @Failure     429 {object} problem.Details "Too many requests"
+// @Failure     500 {object} problem.Details "Internal server error"
+// @Failure     504 {object} problem.Details "Request timed out"
+// @Router      /users/{id} [get]
+func GetUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	projection, err := projection(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := services.GetUserWithProfile(r.Context(), uint(id), projection)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	lastModified := user.UpdatedAt
+	if user.Profile != nil && user.Profile.UpdatedAt.After(lastModified) {
+		lastModified = user.Profile.UpdatedAt
+	}
+	if checkNotModified(w, r, projectionETag(user.Version, projection), lastModified) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projectUser(*user, projection))
+}
+
 // CreateUser creates a new user.
 // @Summary     Create user
 // @Description Create a new user with profile
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       user body     models.User true "User to create"
-// @Success     201  {object} models.User
-// @Failure     400  {string} string "Invalid request payload"
-// @Failure     500  {string} string "Internal server error"
+// @Param       user    body     models.User true  "User to create"
+// @Param       X-Actor header   string      false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     201     {object} models.User
+// @Failure     400     {object} problem.Details "Invalid request payload"
+// @Failure     409     {object} problem.Details "User conflicts with an existing record"
+// @Failure     422     {object} problem.Details "Data violates a constraint"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
 // @Router      /users [post]
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	if err := config.Validate.Struct(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var validationErrs validator.ValidationErrors
+	if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+		problem.Validation(w, r, http.StatusBadRequest, validationErrs)
 		return
 	}
 
-	if err := services.CreateUserWithProfile(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := services.CreateUserWithProfile(r.Context(), &user); err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
 
@@ -106,80 +233,262 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser updates an existing user.
 // @Summary     Update user
-// @Description Update user details by ID
+// @Description Update user details by ID. The write is conditional on the version the client last read, given as the ETag in If-Match or as the version field; if the user changed since, the current user is returned with 412.
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       id   path     int         true "User ID"
-// @Param       user body     models.User true "Updated user"
-// @Success     200  {object} models.User
-// @Failure     400  {string} string "Invalid request payload"
-// @Failure     404  {string} string "User not found"
-// @Failure     500  {string} string "Internal server error"
+// @Param       id       path     int         true  "User ID"
+// @Param       If-Match header   string      false "ETag of the version being updated"
+// @Param       user     body     models.User true  "Updated user"
+// @Param       X-Actor  header   string      false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200      {object} models.User
+// @Failure     400      {object} problem.Details "Invalid request payload"
+// @Failure     404      {object} problem.Details "User not found"
+// @Failure     409      {object} problem.Details "User conflicts with an existing record"
+// @Failure     412      {object} models.User "User was modified; current user"
+// @Failure     422      {object} problem.Details "Data violates a constraint"
+// @Failure     428      {object} problem.Details "If-Match header or version field required"
+// @Failure     429      {object} problem.Details "Too many requests"
+// @Failure     500      {object} problem.Details "Internal server error"
+// @Failure     504      {object} problem.Details "Request timed out"
 // @Router      /users/{id} [put]
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	userID, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
 	user.ID = uint(userID)
 
-	if err := config.Validate.Struct(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var validationErrs validator.ValidationErrors
+	if err := config.Validate.Struct(user); errors.As(err, &validationErrs) {
+		problem.Validation(w, r, http.StatusBadRequest, validationErrs)
 		return
 	}
 
-	if err := services.UpdateUserAndProfile(&user, user.Profile); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	version, ok := expectedVersion(r, user.Version)
+	if !ok {
+		problem.Write(w, r, http.StatusPreconditionRequired, "If-Match header or version field required")
+		return
+	}
+	user.Version = version
+
+	err = services.UpdateUserAndProfile(r.Context(), &user, user.Profile)
+	if errors.Is(err, services.ErrVersionMismatch) {
+		writeCurrentUser(w, r, user.ID, http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
 
+// PatchUser partially updates a user and its profile.
+// @Summary     Patch user
+// @Description Update only the fields present in the patch, e.g. the profile bio without touching profile_picture_url. The body is either a JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json) or a JSON Patch (RFC 6902, Content-Type application/json-patch+json). The patched user is validated again and written in a single transaction.
+// @Description The write is conditional on the version the client last read, given as the ETag in If-Match, as a version member of a merge patch or as a test operation on /version; if the user changed since, the current user is returned with 412.
+// @Tags        users
+// @Accept      application/merge-patch+json
+// @Accept      application/json-patch+json
+// @Produce     json
+// @Param       id       path     int    true  "User ID"
+// @Param       If-Match header   string false "ETag of the version being patched"
+// @Param       patch    body     object true  "Merge patch object or array of JSON Patch operations"
+// @Param       X-Actor  header   string false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200      {object} models.User
+// @Failure     400      {object} problem.Details "Malformed patch document"
+// @Failure     404      {object} problem.Details "User not found"
+// @Failure     409      {object} problem.Details "Test operation failed or user conflicts with an existing record"
+// @Failure     412      {object} models.User "User was modified; current user"
+// @Failure     415      {object} problem.Details "Unsupported patch media type"
+// @Failure     422      {object} problem.Details "Patch cannot be applied or result is invalid"
+// @Failure     428      {object} problem.Details "If-Match header or version required"
+// @Failure     429      {object} problem.Details "Too many requests"
+// @Failure     500      {object} problem.Details "Internal server error"
+// @Failure     504      {object} problem.Details "Request timed out"
+// @Router      /users/{id} [patch]
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	userID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	apply, err := patch.ForContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		problem.Write(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	version, ok := expectedVersion(r, patchVersion(body))
+	if !ok {
+		problem.Write(w, r, http.StatusPreconditionRequired, "If-Match header or version required")
+		return
+	}
+
+	user, err := services.PatchUserWithProfile(r.Context(), uint(userID), version, func(user *models.User) error {
+		return patchUser(user, apply, body)
+	})
+	if errors.Is(err, services.ErrVersionMismatch) {
+		writeCurrentUser(w, r, uint(userID), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		writePatchError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// writeCurrentUser responds with status and the user as it is now, so a
+// client whose precondition failed can merge its change and retry.
+func writeCurrentUser(w http.ResponseWriter, r *http.Request, userID uint, status int) {
+	user, err := services.GetUserWithProfile(r.Context(), userID, services.FullProjection)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(user)
+}
+
 // DeleteUser deletes a user.
 // @Summary     Delete user
-// @Description Delete user by ID
+// @Description Move a user and its profile to the trash by ID. They can be brought back with POST /users/{id}/restore.
 // @Tags        users
 // @Accept      json
 // @Produce     json
-// @Param       id  path     int     true "User ID"
-// @Success     204 {string} string "No Content"
-// @Failure     400 {string} string "Invalid user ID"
-// @Failure     404 {string} string "User not found"
-// @Failure     500 {string} string "Internal server error"
+// @Param       id      path     int     true  "User ID"
+// @Param       X-Actor header   string  false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     204     {string} string "No Content"
+// @Failure     400     {object} problem.Details "Invalid user ID"
+// @Failure     404     {object} problem.Details "User not found"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
 // @Router      /users/{id} [delete]
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	if err := services.DeleteUserWithProfile(uint(id)); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if err := services.DeleteUserWithProfile(r.Context(), uint(id)); err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreUser undeletes a user.
+// @Summary     Restore user
+// @Description Bring a user back from the trash by ID, together with the profile deleted along with it.
+// @Tags        users
+// @Produce     json
+// @Param       id      path     int     true  "User ID"
+// @Param       X-Actor header   string  false "Claimed author of the change, recorded in the audit trail. Not authenticated."
+// @Success     200     {object} models.User
+// @Failure     400     {object} problem.Details "Invalid user ID"
+// @Failure     404     {object} problem.Details "User not found"
+// @Failure     409     {object} problem.Details "User is not deleted"
+// @Failure     429     {object} problem.Details "Too many requests"
+// @Failure     500     {object} problem.Details "Internal server error"
+// @Failure     504     {object} problem.Details "Request timed out"
+// @Router      /users/{id}/restore [post]
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := services.RestoreUserWithProfile(r.Context(), uint(id))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", versionETag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+// defaultBucketWidth is the width of the age histogram buckets, in years,
+// when the request does not set one.
+const defaultBucketWidth = 10
+
+// GetUserStats summarises users.
+// @Summary     User statistics
+// @Description Get the number of users, their minimum, maximum, mean and median age, age percentiles, an age histogram and the share of users who filled in their profile. Accepts the same filters as GET /users.
+// @Tags        users
+// @Produce     json
+// @Param       min_age         query    int  false "Minimum Age"
+// @Param       max_age         query    int  false "Maximum Age"
+// @Param       include_deleted query    bool false "Also count users in the trash"
+// @Param       bucket_width    query    int  false "Width of the histogram buckets in years (default 10)"
+// @Success     200  {object} models.UserStats
+// @Failure     400  {object} problem.Details "Invalid bucket width"
+// @Failure     429  {object} problem.Details "Too many requests"
+// @Failure     500  {object} problem.Details "Internal Server Error"
+// @Failure     504  {object} problem.Details "Request timed out"
+// @Router      /users/stats [get]
+func GetUserStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	minAge, _ := strconv.Atoi(query.Get("min_age"))
+	maxAge, _ := strconv.Atoi(query.Get("max_age"))
+
+	deleted := services.ExcludeDeleted
+	if include, _ := strconv.ParseBool(query.Get("include_deleted")); include {
+		deleted = services.IncludeDeleted
+	}
+
+	bucketWidth := defaultBucketWidth
+	if value := query.Get("bucket_width"); value != "" {
+		width, err := strconv.Atoi(value)
+		if err != nil || width <= 0 {
+			problem.Write(w, r, http.StatusBadRequest, "bucket_width must be a positive integer")
+			return
+		}
+		bucketWidth = width
+	}
+
+	stats, err := services.GetUserStats(r.Context(), minAge, maxAge, deleted, bucketWidth)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
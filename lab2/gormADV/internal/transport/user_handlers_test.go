@@ -1,15 +1,32 @@
 package transport_test
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"gormADV/internal/database"
+	"gormADV/internal/logging"
 	"gormADV/internal/models"
+	"gormADV/internal/problem"
 	"gormADV/internal/services"
+	"gormADV/internal/transport"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 var mockDB *gorm.DB
@@ -34,17 +51,47 @@ func setupMockDB(t *testing.T) {
 	database.DB = mockDB
 }
 
+// expectLockUser expects a user to be loaded and locked for update; the
+// caller expects the profile preload if rows is not empty.
+func expectLockUser(userID int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL ORDER BY "users"\."id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(userID, 1).
+		WillReturnRows(rows)
+}
+
+// expectAudit expects an entry to be added to the audit trail.
+func expectAudit(userID int, action string) {
+	mock.ExpectQuery(`INSERT INTO "user_audit" \("user_id","action","before","after","actor","request_id","created_at"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7\) RETURNING "id"`).
+		WithArgs(userID, action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+// jsonContaining matches a JSON argument that contains s.
+type jsonContaining string
+
+func (s jsonContaining) Match(v driver.Value) bool {
+	var data string
+	switch v := v.(type) {
+	case []byte:
+		data = string(v)
+	case string:
+		data = v
+	}
+	return strings.Contains(data, string(s))
+}
+
 func TestCreateUserWithProfile(t *testing.T) {
 	setupMockDB(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(`INSERT INTO "users" \("created_at","updated_at","deleted_at","name","age"\) VALUES \(\$1,\$2,\$3,\$4,\$5\) RETURNING "id"`).
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "John Doe", 25).
+	mock.ExpectQuery(`INSERT INTO "users" \("created_at","updated_at","deleted_at","name","age","version"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) RETURNING "id"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "John Doe", 25, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	expectAudit(1, "create")
 	mock.ExpectCommit()
 
 	user := &models.User{Name: "John Doe", Age: 25}
-	err := services.CreateUserWithProfile(user)
+	err := services.CreateUserWithProfile(context.Background(), user)
 	if err != nil {
 		t.Errorf("Создание пользователя завершилось с ошибкой: %v", err)
 	}
@@ -58,7 +105,7 @@ func TestGetUsersWithProfiles(t *testing.T) {
 	setupMockDB(t)
 
 	countRows := sqlmock.NewRows([]string{"count"}).AddRow(2)
-	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE age >= \$1 AND age <= \$2 AND "users"\."deleted_at" IS NULL`).
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE users\.age >= \$1 AND users\.age <= \$2 AND "users"\."deleted_at" IS NULL`).
 		WithArgs(18, 30).
 		WillReturnRows(countRows)
 
@@ -66,7 +113,7 @@ func TestGetUsersWithProfiles(t *testing.T) {
 		AddRow(1, "John Doe", 25).
 		AddRow(2, "Jane Doe", 30)
 
-	mock.ExpectQuery(`SELECT \* FROM "users" WHERE age >= \$1 AND age <= \$2 AND "users"\."deleted_at" IS NULL ORDER BY name ASC LIMIT \$3`).
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE users\.age >= \$1 AND users\.age <= \$2 AND "users"\."deleted_at" IS NULL ORDER BY users\.name ASC,users\.id ASC LIMIT \$3`).
 		WithArgs(18, 30, 10).
 		WillReturnRows(rows)
 
@@ -78,7 +125,7 @@ func TestGetUsersWithProfiles(t *testing.T) {
 		WithArgs(1, 2).
 		WillReturnRows(profileRows)
 
-	users, totalCount, err := services.GetUsersWithProfiles(18, 30, 1, 10, "name_asc")
+	users, totalCount, err := services.GetUsersWithProfiles(context.Background(), 18, 30, 1, 10, services.Sort{{Field: "name"}}, services.ExcludeDeleted, services.FullProjection)
 	if err != nil {
 		t.Errorf("Получение списка пользователей завершилось с ошибкой: %v", err)
 	}
@@ -96,13 +143,135 @@ func TestGetUsersWithProfiles(t *testing.T) {
 	}
 }
 
+func TestGetUsersSortedByProfile(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE "users"\."deleted_at" IS NULL$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT "users"\."id",.* FROM "users" LEFT JOIN profiles ON profiles\.user_id = users\.id AND \(profiles\.deleted_at IS NULL OR profiles\.deleted_at >= users\.deleted_at\) WHERE "users"\."deleted_at" IS NULL ORDER BY profiles\.bio DESC,users\.created_at ASC,users\.id ASC LIMIT \$1$`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow(1, "John Doe", 25))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1 AND "profiles"\."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Dev"))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("GET", "/users?sort=profile.bio:desc,created_at", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+
+	for _, sort := range []string{"email", "profile.user_id", "age:up", "age,age"} {
+		req := httptest.NewRequest("GET", "/users?sort="+sort, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("sort=%s: неверный код статуса: получили %v, ожидали %v", sort, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUsersFields(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE "users"\."deleted_at" IS NULL$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT users\.id,users\.name FROM "users" WHERE "users"\."deleted_at" IS NULL ORDER BY users\.id ASC LIMIT \$1$`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "John Doe"))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("GET", "/users?fields=name,id", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+
+	var response struct {
+		Users []map[string]interface{} `json:"users"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Не удалось разобрать ответ: %v", err)
+	}
+	want := map[string]interface{}{"ID": float64(1), "name": "John Doe"}
+	if len(response.Users) != 1 || !reflect.DeepEqual(response.Users[0], want) {
+		t.Errorf("Неверные пользователи: получили %v, ожидали [%v]", response.Users, want)
+	}
+
+	for _, query := range []string{"fields=email", "fields=id,profile", "include=posts"} {
+		req := httptest.NewRequest("GET", "/users?"+query, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: неверный код статуса: получили %v, ожидали %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUserProjectionETag(t *testing.T) {
+	setupMockDB(t)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	tests := []struct {
+		url, ifNoneMatch string
+		status           int
+		etag             string
+	}{
+		{"/users/1?fields=name,id", "", http.StatusOK, `"3-id+name"`},
+		{"/users/1?fields=id,name", `"3-id+name"`, http.StatusNotModified, `"3-id+name"`},
+		{"/users/1?fields=id,name", `"3"`, http.StatusOK, `"3-id+name"`},
+		{"/users/1?fields=id,name&include=profile", `"3-id+name"`, http.StatusOK, `"3-id+name+profile"`},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery(`SELECT .* FROM "users" WHERE .*"users"\."id" = \$1`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version"}).AddRow(1, "John Doe", 3))
+		if strings.Contains(tt.url, "include=profile") {
+			mock.ExpectQuery(`SELECT .* FROM "profiles"`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Bio for John"))
+		}
+
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status || rr.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s (If-None-Match %s): получили %v %s, ожидали %v %s",
+				tt.url, tt.ifNoneMatch, rr.Code, rr.Header().Get("ETag"), tt.status, tt.etag)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
 func TestUpdateUserAndProfile(t *testing.T) {
 	setupMockDB(t)
 
 	user := &models.User{
-		Model: gorm.Model{ID: 1},
-		Name:  "Jane Doe",
-		Age:   30,
+		Model:   gorm.Model{ID: 1},
+		Name:    "Jane Doe",
+		Age:     30,
+		Version: 1,
 	}
 	profile := &models.Profile{
 		UserID:            1,
@@ -111,21 +280,29 @@ func TestUpdateUserAndProfile(t *testing.T) {
 	}
 
 	mock.ExpectBegin()
+	expectLockUser(1, sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Bio for John"))
 
-	mock.ExpectExec(`UPDATE "users" SET "age"=\$1,"name"=\$2,"updated_at"=\$3 WHERE id = \$4 AND "users"."deleted_at" IS NULL`).
-		WithArgs(30, "Jane Doe", sqlmock.AnyArg(), 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`UPDATE "users" SET "age"=\$1,"name"=\$2,"version"=version \+ 1,"updated_at"=\$3 WHERE id = \$4 AND version = \$5 AND "users"."deleted_at" IS NULL RETURNING "version"`).
+		WithArgs(30, "Jane Doe", sqlmock.AnyArg(), 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
 
 	mock.ExpectExec(`UPDATE "profiles" SET "bio"=\$1,"profile_picture_url"=\$2,"updated_at"=\$3 WHERE user_id = \$4 AND "profiles"."deleted_at" IS NULL`).
 		WithArgs("Some Profile Data", "http://example.com/profile.jpg", sqlmock.AnyArg(), 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
+	expectAudit(1, "update")
 	mock.ExpectCommit()
 
-	err := services.UpdateUserAndProfile(user, profile)
+	err := services.UpdateUserAndProfile(context.Background(), user, profile)
 	if err != nil {
 		t.Errorf("Обновление пользователя завершилось с ошибкой: %v", err)
 	}
+	if user.Version != 2 {
+		t.Errorf("Неверная версия: получили %v, ожидали 2", user.Version)
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Ожидания не были выполнены: %v", err)
@@ -136,6 +313,10 @@ func TestDeleteUserWithProfile(t *testing.T) {
 	setupMockDB(t)
 
 	mock.ExpectBegin()
+	expectLockUser(1, sqlmock.NewRows([]string{"id", "name", "age"}).AddRow(1, "John Doe", 25))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(1, 1))
 
 	mock.ExpectExec(`UPDATE "users" SET "deleted_at"=\$1 WHERE "users"."id" = \$2 AND "users"."deleted_at" IS NULL`).
 		WithArgs(sqlmock.AnyArg(), 1).
@@ -144,9 +325,10 @@ func TestDeleteUserWithProfile(t *testing.T) {
 	mock.ExpectExec(`UPDATE "profiles" SET "deleted_at"=\$1 WHERE user_id = \$2 AND "profiles"."deleted_at" IS NULL`).
 		WithArgs(sqlmock.AnyArg(), 1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(1, "delete")
 	mock.ExpectCommit()
 
-	err := services.DeleteUserWithProfile(1)
+	err := services.DeleteUserWithProfile(context.Background(), 1)
 	if err != nil {
 		t.Errorf("Удаление пользователя завершилось с ошибкой: %v", err)
 	}
@@ -155,3 +337,730 @@ func TestDeleteUserWithProfile(t *testing.T) {
 		t.Errorf("Ожидания не были выполнены: %v", err)
 	}
 }
+
+func TestGetUsersWithProfilesCanceled(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users"`).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err := services.GetUsersWithProfiles(ctx, 0, 0, 1, 10, nil, services.ExcludeDeleted, services.FullProjection)
+	if err == nil {
+		t.Error("Ожидалась ошибка после отмены контекста")
+	}
+}
+
+func TestCreateUserWithProfileDuplicate(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "users"`).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "idx_users_name"})
+	mock.ExpectRollback()
+
+	err := services.CreateUserWithProfile(context.Background(), &models.User{Name: "John Doe", Age: 25})
+	if !errors.Is(err, services.ErrConflict) {
+		t.Errorf("Ожидалась ошибка конфликта, получили %v", err)
+	}
+}
+
+func TestCreateUserValidationProblem(t *testing.T) {
+	setupMockDB(t)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	body := `{"age":200,"profile":{"profile_picture_url":"not a url"}}`
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("Неверный Content-Type: получили %q, ожидали %q", ct, problem.ContentType)
+	}
+	var details problem.Details
+	if err := json.Unmarshal(rr.Body.Bytes(), &details); err != nil {
+		t.Fatalf("Не удалось разобрать ответ: %v", err)
+	}
+	if details.Type != problem.ValidationType || details.Status != http.StatusBadRequest || details.Instance != "/users" || details.RequestID != "req-1" {
+		t.Errorf("Неверные детали проблемы: %+v", details)
+	}
+	want := []problem.FieldError{
+		{Field: "name", Rule: "required", Message: "is required"},
+		{Field: "age", Rule: "lte", Message: "must be at most 150"},
+		{Field: "profile.profile_picture_url", Rule: "url", Message: "must be a URL"},
+	}
+	if !slices.Equal(details.Errors, want) {
+		t.Errorf("Неверные ошибки полей: получили %v, ожидали %v", details.Errors, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestUpdateUserValidationProblem(t *testing.T) {
+	setupMockDB(t)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	body := `{"name":"Jane Doe","age":-1,"version":1}`
+	req := httptest.NewRequest("PUT", "/users/1", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+	var details problem.Details
+	if err := json.Unmarshal(rr.Body.Bytes(), &details); err != nil {
+		t.Fatalf("Не удалось разобрать ответ: %v", err)
+	}
+	want := []problem.FieldError{{Field: "age", Rule: "gte", Message: "must be at least 0"}}
+	if details.Type != problem.ValidationType || !slices.Equal(details.Errors, want) {
+		t.Errorf("Неверные детали проблемы: %+v", details)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	setupMockDB(t)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	tests := []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "/users/abc", http.StatusBadRequest},
+		{"GET", "/users?sort=email", http.StatusBadRequest},
+		{"GET", "/nowhere", http.StatusNotFound},
+		{"POST", "/users/1", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+			t.Errorf("%s %s: неверный Content-Type: получили %q, ожидали %q", tt.method, tt.url, ct, problem.ContentType)
+		}
+		var details problem.Details
+		if err := json.Unmarshal(rr.Body.Bytes(), &details); err != nil {
+			t.Fatalf("%s %s: не удалось разобрать ответ: %v", tt.method, tt.url, err)
+		}
+		if rr.Code != tt.status || details.Status != tt.status || details.Title != http.StatusText(tt.status) {
+			t.Errorf("%s %s: неверный статус: получили %d (%+v), ожидали %d", tt.method, tt.url, rr.Code, details, tt.status)
+		}
+		if details.Type != "about:blank" || details.Instance != tt.url || details.RequestID != rr.Header().Get("X-Request-ID") || details.RequestID == "" {
+			t.Errorf("%s %s: неверные детали проблемы: %+v", tt.method, tt.url, details)
+		}
+	}
+}
+
+func TestUpdateUserAndProfileNotFound(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	expectLockUser(42, sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	user := &models.User{Model: gorm.Model{ID: 42}, Name: "Jane Doe", Age: 30}
+	err := services.UpdateUserAndProfile(context.Background(), user, nil)
+	if !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Ожидалась ошибка ErrNotFound, получили %v", err)
+	}
+}
+
+func TestUpdateUserAndProfileVersionMismatch(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	expectLockUser(1, sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 3))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	mock.ExpectQuery(`UPDATE "users" SET .* WHERE id = \$4 AND version = \$5`).
+		WithArgs(30, "Jane Doe", sqlmock.AnyArg(), 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+
+	user := &models.User{Model: gorm.Model{ID: 1}, Name: "Jane Doe", Age: 30, Version: 1}
+	err := services.UpdateUserAndProfile(context.Background(), user, nil)
+	if !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("Ожидалась ошибка ErrVersionMismatch, получили %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestGetUserWithProfile(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL ORDER BY "users"\."id" LIMIT \$2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow(1, "John Doe", 25))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1 AND "profiles"\."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Bio for John"))
+
+	user, err := services.GetUserWithProfile(context.Background(), 1, services.FullProjection)
+	if err != nil {
+		t.Fatalf("Получение пользователя завершилось с ошибкой: %v", err)
+	}
+	if user.Name != "John Doe" || user.Profile == nil || user.Profile.Bio != "Bio for John" {
+		t.Errorf("Неожиданный пользователь: %+v", user)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestGetUserWithProfileNotFound(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}))
+
+	_, err := services.GetUserWithProfile(context.Background(), 42, services.FullProjection)
+	if !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Ожидалась ошибка ErrNotFound, получили %v", err)
+	}
+}
+
+func TestPatchUserWithProfile(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL ORDER BY "users"\."id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio", "profile_picture_url"}).
+			AddRow(1, 1, "Bio for John", "http://example.com/john.jpg"))
+	mock.ExpectExec(`UPDATE "profiles" SET "bio"=\$1,"updated_at"=\$2 WHERE user_id = \$3 AND "profiles"\."deleted_at" IS NULL`).
+		WithArgs("Ops", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "users" SET "version"=version \+ 1,"updated_at"=\$1 WHERE id = \$2 AND "users"\."deleted_at" IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 2))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio", "profile_picture_url"}).
+			AddRow(1, 1, "Ops", "http://example.com/john.jpg"))
+	expectAudit(1, "update")
+	mock.ExpectCommit()
+
+	user, err := services.PatchUserWithProfile(context.Background(), 1, 1, func(user *models.User) error {
+		user.Profile.Bio = "Ops"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Частичное обновление завершилось с ошибкой: %v", err)
+	}
+	if user.Profile.Bio != "Ops" || user.Profile.ProfilePictureURL != "http://example.com/john.jpg" {
+		t.Errorf("Неверный профиль: %+v", user.Profile)
+	}
+	if user.Version != 2 {
+		t.Errorf("Неверная версия: получили %v, ожидали 2", user.Version)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPatchUserWithProfileNotFound(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}))
+	mock.ExpectRollback()
+
+	_, err := services.PatchUserWithProfile(context.Background(), 42, 0, func(user *models.User) error {
+		return nil
+	})
+	if !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Ожидалась ошибка ErrNotFound, получили %v", err)
+	}
+}
+
+func TestPatchUserInvalidResult(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectQuery(`SELECT \* FROM "profiles"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio", "profile_picture_url"}).
+			AddRow(1, 1, "Bio for John", "http://example.com/john.jpg"))
+	mock.ExpectRollback()
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"profile":{"profile_picture_url":"not a url"}}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusUnprocessableEntity)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestPatchUserStale(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users" .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 3))
+	mock.ExpectQuery(`SELECT \* FROM "profiles"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`SELECT \* FROM "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 3))
+	mock.ExpectQuery(`SELECT \* FROM "profiles"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"age":31}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"2"`)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusPreconditionFailed)
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("Неверный ETag: получили %v, ожидали %v", etag, `"3"`)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestUpdateUserPreconditionRequired(t *testing.T) {
+	setupMockDB(t)
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"name":"Jane Doe","age":30}`))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusPreconditionRequired {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusPreconditionRequired)
+	}
+}
+
+func TestGetDeletedUsers(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE users\.deleted_at IS NOT NULL$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE users\.deleted_at IS NOT NULL ORDER BY users\.id ASC LIMIT \$1$`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "deleted_at"}).AddRow(1, "John Doe", 25, time.Now()))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1 AND \(profiles\.deleted_at IS NULL OR profiles\.deleted_at >= \(SELECT users\.deleted_at FROM users WHERE users\.id = profiles\.user_id\)\)$`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Bio for John"))
+
+	users, totalCount, err := services.GetUsersWithProfiles(context.Background(), 0, 0, 1, 10, nil, services.OnlyDeleted, services.FullProjection)
+	if err != nil {
+		t.Fatalf("Получение корзины завершилось с ошибкой: %v", err)
+	}
+	if totalCount != 1 || len(users) != 1 || !users[0].DeletedAt.Valid || users[0].Profile == nil {
+		t.Errorf("Неверное содержимое корзины: %+v", users)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestRestoreUserWithProfile(t *testing.T) {
+	setupMockDB(t)
+
+	deletedAt := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 ORDER BY "users"\."id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version", "deleted_at"}).AddRow(1, "John Doe", 25, 1, deletedAt))
+	mock.ExpectExec(`UPDATE "users" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE id = \$3$`).
+		WithArgs(nil, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "profiles" SET "deleted_at"=\$1,"updated_at"=\$2 WHERE user_id = \$3 AND deleted_at >= \$4$`).
+		WithArgs(nil, sqlmock.AnyArg(), 1, deletedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1 AND "users"\."deleted_at" IS NULL`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "version"}).AddRow(1, "John Doe", 25, 1))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1 AND "profiles"\."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}).AddRow(1, 1, "Bio for John"))
+	mock.ExpectQuery(`INSERT INTO "user_audit"`).
+		WithArgs(1, "restore", jsonContaining(`"DeletedAt":"2024-01-02T15:04:05Z"`), jsonContaining(`"DeletedAt":null`),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	user, err := services.RestoreUserWithProfile(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Восстановление пользователя завершилось с ошибкой: %v", err)
+	}
+	if user.DeletedAt.Valid || user.Profile == nil || user.Profile.Bio != "Bio for John" {
+		t.Errorf("Пользователь восстановлен без профиля: %+v", user)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestRestoreUserNotDeleted(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "users"`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age", "deleted_at"}).AddRow(1, "John Doe", 25, nil))
+	mock.ExpectRollback()
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("POST", "/users/1/restore", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusConflict)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestCreateUserRecordsAudit(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "users"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "user_audit"`).
+		WithArgs(1, "create", nil, sqlmock.AnyArg(), "alice", "req-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"John Doe","age":25}`))
+	req.Header.Set("X-Actor", "alice")
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusCreated)
+	}
+	if id := rr.Header().Get("X-Request-ID"); id != "req-1" {
+		t.Errorf("Неверный X-Request-ID: получили %q, ожидали %q", id, "req-1")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestGetAudit(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "user_audit" WHERE user_id = \$1 AND action = \$2$`).
+		WithArgs(1, "update").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "user_audit" WHERE user_id = \$1 AND action = \$2 ORDER BY id DESC LIMIT \$3$`).
+		WithArgs(1, "update", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "action", "before", "after", "actor"}).
+			AddRow(7, 1, "update", `{"name":"John Doe"}`, `{"name":"Jane Doe"}`, "alice"))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("GET", "/audit?user_id=1&action=update", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	var response models.AuditListResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.TotalItems != 1 || len(response.Entries) != 1 || string(response.Entries[0].After) != `{"name":"Jane Doe"}` {
+		t.Errorf("Неверный журнал: %+v", response)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/audit?action=purge", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	exportRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "name", "age", "version", "id", "created_at", "updated_at", "bio", "profile_picture_url"}).
+			AddRow(1, created, created, "John Doe", 25, 1, 1, created, created, "Dev, Ops", "http://example.com/john.jpg").
+			AddRow(2, created, created, "Jane Doe", 30, 2, nil, nil, nil, nil, nil)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/users/export?min_age=18", "id,name,age,version,created_at,updated_at\n" +
+			"1,John Doe,25,1,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z\n" +
+			"2,Jane Doe,30,2,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z\n"},
+		{"/users/export?min_age=18&flatten=true", "id,name,age,version,created_at,updated_at,profile_bio,profile_picture_url\n" +
+			"1,John Doe,25,1,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z,\"Dev, Ops\",http://example.com/john.jpg\n" +
+			"2,Jane Doe,30,2,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z,,\n"},
+		{"/users/export?min_age=18&format=ndjson&flatten=true",
+			`{"id":1,"name":"John Doe","age":25,"version":1,"created_at":"2024-01-02T15:04:05Z","updated_at":"2024-01-02T15:04:05Z","profile_bio":"Dev, Ops","profile_picture_url":"http://example.com/john.jpg"}` + "\n" +
+				`{"id":2,"name":"Jane Doe","age":30,"version":2,"created_at":"2024-01-02T15:04:05Z","updated_at":"2024-01-02T15:04:05Z","profile_bio":"","profile_picture_url":""}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		setupMockDB(t)
+		mock.ExpectQuery(`SELECT users\.id, .*, profiles\.profile_picture_url FROM "users" LEFT JOIN profiles ON profiles\.user_id = users\.id AND profiles\.deleted_at IS NULL WHERE users\.age >= \$1 AND "users"\."deleted_at" IS NULL ORDER BY users\.id ASC$`).
+			WithArgs(18).
+			WillReturnRows(exportRows())
+
+		r := mux.NewRouter()
+		transport.RegisterRoutes(r)
+
+		req := httptest.NewRequest("GET", tt.url, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: неверный код статуса: получили %v, ожидали %v", tt.url, status, http.StatusOK)
+		}
+		if body := rr.Body.String(); body != tt.want {
+			t.Errorf("%s: неверное тело ответа:\nполучили %q\nожидали  %q", tt.url, body, tt.want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Ожидания не были выполнены: %v", err)
+		}
+	}
+}
+
+func TestExportUsersNested(t *testing.T) {
+	setupMockDB(t)
+	mock.ExpectQuery(`SELECT users\.id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "name", "age", "version", "id", "created_at", "updated_at", "bio", "profile_picture_url"}).
+			AddRow(1, time.Now(), time.Now(), "John Doe", 25, 1, 7, time.Now(), time.Now(), "Dev", ""))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("GET", "/users/export?format=json", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var users []models.User
+	if err := json.NewDecoder(rr.Body).Decode(&users); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(users) != 1 || users[0].Profile == nil || users[0].Profile.ID != 7 || users[0].Profile.Bio != "Dev" {
+		t.Errorf("Неверный экспорт: %+v", users)
+	}
+}
+
+func TestExportUsersCSVFormulas(t *testing.T) {
+	setupMockDB(t)
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	mock.ExpectQuery(`SELECT users\.id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "name", "age", "version", "id", "created_at", "updated_at", "bio", "profile_picture_url"}).
+			AddRow(1, created, created, "=HYPERLINK(\"http://evil\")", 25, 1, 7, created, created, "@SUM(A1)", "").
+			AddRow(2, created, created, "Jane-Doe", 30, 1, nil, nil, nil, nil, nil))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/users/export?flatten=true", nil))
+
+	want := "id,name,age,version,created_at,updated_at,profile_bio,profile_picture_url\n" +
+		"1,\"'=HYPERLINK(\"\"http://evil\"\")\",25,1,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z,'@SUM(A1),\n" +
+		"2,Jane-Doe,30,1,2024-01-02T15:04:05Z,2024-01-02T15:04:05Z,,\n"
+	if body := rr.Body.String(); body != want {
+		t.Errorf("Неверное тело ответа:\nполучили %q\nожидали  %q", body, want)
+	}
+}
+
+func TestGetUserStats(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS count, .*percentile_cont\(0\.5\) WITHIN GROUP \(ORDER BY age\), 0\) AS p50, .* COUNT\(\*\) FILTER \(WHERE EXISTS \(SELECT 1 FROM profiles .*\)\) AS with_profile FROM "users" WHERE users\.age >= \$1 AND "users"\."deleted_at" IS NULL$`).
+		WithArgs(18).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min_age", "max_age", "avg_age", "p25", "p50", "p75", "p90", "p95", "p99", "with_profile"}).
+			AddRow(4, 18, 44, 29.5, 23.25, 28, 34.25, 40.1, 42.05, 43.61, 3))
+	mock.ExpectQuery(`SELECT age / \$1 \* \$2 AS bucket, COUNT\(\*\) AS count FROM "users" WHERE users\.age >= \$3 AND "users"\."deleted_at" IS NULL GROUP BY "bucket"`).
+		WithArgs(10, 10, 18).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow(10, 1).
+			AddRow(20, 1).
+			AddRow(40, 2))
+	mock.ExpectCommit()
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+
+	req := httptest.NewRequest("GET", "/users/stats?min_age=18", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	var stats models.UserStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if stats.Count != 4 || stats.MedianAge != 28 || stats.Percentiles["p99"] != 43.61 || stats.ProfileShare != 0.75 {
+		t.Errorf("Неверная сводка: %+v", stats)
+	}
+	want := []models.AgeBucket{{From: 10, To: 20, Count: 1}, {From: 20, To: 30, Count: 1}, {From: 30, To: 40}, {From: 40, To: 50, Count: 2}}
+	if !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Неверная гистограмма: %+v", stats.Histogram)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/users/stats?bucket_width=-5", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetUserStatsWideSpread(t *testing.T) {
+	setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COUNT\(\*\) AS count, `).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "min_age", "max_age", "avg_age", "p25", "p50", "p75", "p90", "p95", "p99", "with_profile"}).
+			AddRow(2, 3, 2_000_000_000, 1e9, 5e8, 1e9, 1.5e9, 1.8e9, 1.9e9, 1.98e9, 0))
+	mock.ExpectQuery(`SELECT age / \$1 \* \$2 AS bucket`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow(2_000_000_000, 1).
+			AddRow(3, 1))
+	mock.ExpectCommit()
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/users/stats?bucket_width=1", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Неверный код статуса: получили %v, ожидали %v", status, http.StatusOK)
+	}
+	var stats models.UserStats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	want := []models.AgeBucket{{From: 3, To: 4, Count: 1}, {From: 2_000_000_000, To: 2_000_000_001, Count: 1}}
+	if !slices.Equal(stats.Histogram, want) {
+		t.Errorf("Гистограмма с пустыми корзинами сверх предела: %d корзин", len(stats.Histogram))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	setupMockDB(t)
+	database.DB = mockDB.Session(&gorm.Session{Logger: logging.GormLogger{}})
+
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(&buf, slog.LevelDebug))
+	defer slog.SetDefault(defaultLogger)
+
+	mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"\."id" = \$1`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow(1, "John Doe", 25))
+	mock.ExpectQuery(`SELECT \* FROM "profiles" WHERE "profiles"\."user_id" = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "bio"}))
+
+	r := mux.NewRouter()
+	transport.RegisterRoutes(r)
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Неверное число записей: получили %d, ожидали 2:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var record struct {
+			Level     string `json:"level"`
+			SQL       string `json:"sql"`
+			RequestID string `json:"request_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Запись не в формате JSON: %v", err)
+		}
+		if record.Level != "DEBUG" || record.RequestID != "req-1" || !strings.Contains(record.SQL, "= $1") {
+			t.Errorf("Неверная запись: %s", line)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Ожидания не были выполнены: %v", err)
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"directCon/internal/app"
 	"fmt"
+	"log"
 )
 
 func main() {
 	fmt.Println("Сервер запущен на порту 8080")
-	app.Run()
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +13,16 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	DBPort     string
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout bound
+	// the phases of an HTTP connection, as in http.Server.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration
 }
 
 var AppConfig *Config
@@ -27,5 +38,23 @@ func init() {
 		DBPassword: os.Getenv("DB_PASSWORD"),
 		DBName:     os.Getenv("DB_NAME_EASY"),
 		DBPort:     os.Getenv("DB_PORT"),
+
+		ReadHeaderTimeout: durationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       durationEnv("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      durationEnv("WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       durationEnv("IDLE_TIMEOUT", 120*time.Second),
+		ShutdownTimeout:   durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+}
+
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
 	}
+	return d
 }
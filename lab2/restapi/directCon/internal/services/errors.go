@@ -0,0 +1,30 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid data")
+)
+
+// translateError wraps Postgres integrity errors in ErrConflict or
+// ErrInvalid. Other errors are returned unchanged.
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case "23505", "23503":
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case "23514", "23502", "22001":
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return err
+}
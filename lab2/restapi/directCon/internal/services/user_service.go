@@ -3,7 +3,6 @@ package services
 import (
 	"directCon/internal/database"
 	"directCon/internal/models"
-	"errors"
 )
 
 func CreateUser(user models.User) error {
@@ -18,7 +17,7 @@ INSERT INTO users (name, age) VALUES ($1, $2) RETURNING id
                               `
 	err = db.QueryRow(query, user.Name, user.Age).Scan(&user.ID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
@@ -63,11 +62,11 @@ UPDATE users SET name=$1, age=$2 WHERE id=$3
 `
 	result, err := db.Exec(query, user.Name, user.Age, user.ID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrNotFound
 	}
 	return nil
 }
@@ -88,7 +87,7 @@ DELETE FROM users WHERE id=$1
 	}
 
 	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrNotFound
 	}
 	return nil
 }
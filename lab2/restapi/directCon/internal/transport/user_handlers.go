@@ -2,8 +2,10 @@ package transport
 
 import (
 	"directCon/internal/models"
+	"directCon/internal/problem"
 	"directCon/internal/services"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
@@ -15,12 +17,19 @@ func RegisterRoutes(r *mux.Router) {
 	r.HandleFunc("/users", CreateUser).Methods("POST")
 	r.HandleFunc("/users/{id}", UpdateUser).Methods("PUT")
 	r.HandleFunc("/users/{id}", DeleteUser).Methods("DELETE")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusNotFound, "No such resource")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.Write(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on this resource")
+	})
 }
 
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	users, err := services.GetUsers()
 	if err != nil {
-		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "Error getting users")
 		return
 	}
 
@@ -31,12 +40,19 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid input")
 		return
 	}
 
 	if err := services.CreateUser(user); err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, services.ErrConflict):
+			problem.Write(w, r, http.StatusConflict, "User already exists")
+		case errors.Is(err, services.ErrInvalid):
+			problem.Write(w, r, http.StatusUnprocessableEntity, "Invalid user data")
+		default:
+			problem.Write(w, r, http.StatusInternalServerError, "Error creating user")
+		}
 		return
 	}
 
@@ -47,31 +63,36 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	var user models.User
 
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid input")
 		return
 	}
 	user.ID = id
 
 	userData, err := json.Marshal(user)
 	if err != nil {
-		http.Error(w, "Error processing data", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, "Error processing data")
 		return
 	}
 
 	log.Printf("Updating user: %s", userData)
 
 	if err := services.UpdateUser(user); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Error updating user", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, services.ErrNotFound):
+			problem.Write(w, r, http.StatusNotFound, "User not found")
+		case errors.Is(err, services.ErrConflict):
+			problem.Write(w, r, http.StatusConflict, "User already exists")
+		case errors.Is(err, services.ErrInvalid):
+			problem.Write(w, r, http.StatusUnprocessableEntity, "Invalid user data")
+		default:
+			problem.Write(w, r, http.StatusInternalServerError, "Error updating user")
 		}
 		return
 	}
@@ -83,15 +104,15 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	if err := services.DeleteUser(id); err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
+		if errors.Is(err, services.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, "User not found")
 		} else {
-			http.Error(w, "Error deleting user", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusInternalServerError, "Error deleting user")
 		}
 		return
 	}
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"gorm/internal/app"
 	"gorm/internal/database"
+	"log"
 )
 
 func main() {
 	fmt.Println("Сервер запущен на порту 8080")
 	database.ConnectDB()
-	app.Run()
+	if err := app.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,17 +1,71 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm/internal/config"
+	"gorm/internal/database"
 	"gorm/internal/transport"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
-func Run() {
+// Run serves the API until SIGINT or SIGTERM arrives or the listener fails,
+// then drains in-flight requests for up to the shutdown timeout and closes
+// the database. A second signal kills the process without waiting.
+func Run() error {
 	r := mux.NewRouter()
 
 	transport.RegisterRoutes(r)
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	srv := &http.Server{
+		Addr:              ":8080",
+		Handler:           r,
+		ReadHeaderTimeout: config.AppConfig.ReadHeaderTimeout,
+		ReadTimeout:       config.AppConfig.ReadTimeout,
+		WriteTimeout:      config.AppConfig.WriteTimeout,
+		IdleTimeout:       config.AppConfig.IdleTimeout,
+	}
+	err := serve(srv)
+	if closeErr := database.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to close the database: %w", closeErr))
+	}
+	return err
+}
+
+func serve(srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.ListenAndServe()
+	}()
+	log.Printf("Listening on %s", srv.Addr)
+
+	var err error
+	select {
+	case err = <-listenErr:
+		err = fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.AppConfig.ShutdownTimeout)
+	defer cancel()
+	if drainErr := srv.Shutdown(shutdownCtx); drainErr != nil {
+		srv.Close()
+		return errors.Join(err, fmt.Errorf("failed to drain requests: %w", drainErr))
+	}
+	if err == nil {
+		log.Println("Server stopped.")
+	}
+	return err
 }
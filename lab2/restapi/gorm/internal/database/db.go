@@ -25,3 +25,16 @@ func ConnectDB() {
 	}
 	log.Println("Успешное подключение к базе данных")
 }
+
+// Close closes the connection pool. Queries in progress are allowed to
+// finish.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database: %w", err)
+	}
+	return sqlDB.Close()
+}
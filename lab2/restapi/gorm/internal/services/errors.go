@@ -0,0 +1,30 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("conflict")
+	ErrInvalid  = errors.New("invalid data")
+)
+
+// translateError wraps Postgres integrity errors in ErrConflict or
+// ErrInvalid. Other errors are returned unchanged.
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case "23505", "23503":
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	case "23514", "23502", "22001":
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return err
+}
@@ -1,14 +1,13 @@
 package services
 
 import (
-	"errors"
 	"gorm/internal/database"
 	"gorm/internal/models"
 )
 
 func CreateUser(user models.User) error {
 	if err := database.DB.Create(&user).Error; err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
@@ -24,10 +23,10 @@ func GetUsers() ([]models.User, error) {
 func UpdateUser(user models.User) error {
 	result := database.DB.Model(&user).Updates(models.User{Name: user.Name, Age: user.Age})
 	if result.Error != nil {
-		return result.Error
+		return translateError(result.Error)
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrNotFound
 	}
 	return nil
 }
@@ -38,7 +37,7 @@ func DeleteUser(id int) error {
 		return result.Error
 	}
 	if result.RowsAffected == 0 {
-		return errors.New("user not found")
+		return ErrNotFound
 	}
 	return nil
 }